@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/chewxy/lingo"
+	"github.com/pkg/errors"
+)
+
+// cacheVersion changes whenever the format of the cache does, so that old entries are never read.
+const cacheVersion = "document-1"
+
+// modelFingerprint identifies the POS tagger, dependency parser and clusters that were loaded.
+// Parses made by different models never share a cache entry. Hashing the models takes a while,
+// so it is only done the first time the cache is used. See loadFingerprint.
+var (
+	modelFingerprint []byte
+	modelLocs        []string // files the models were loaded from. Set by loadModels
+	fingerprintOnce  sync.Once
+	fingerprintErr   error
+)
+
+// loadFingerprint computes modelFingerprint once. It is safe for concurrent use.
+func loadFingerprint() error {
+	fingerprintOnce.Do(func() {
+		modelFingerprint, fingerprintErr = fingerprint(modelLocs...)
+	})
+	return fingerprintErr
+}
+
+// cachedAnnotation is the part of a *lingo.Annotation that survives the parse cache.
+type cachedAnnotation struct {
+	Value          string
+	LexemeType     lingo.LexemeType
+	POSTag         lingo.POSTag
+	DependencyType lingo.DependencyType
+	Lemma          string
+	Lowered        string
+	Stem           string
+	Head           int // index of the head in the sentence. -1 if there is none
+}
+
+type cachedSentence []cachedAnnotation
+
+type cachedDocument []cachedSentence
+
+func newCachedDocument(doc Document) cachedDocument {
+	retVal := make(cachedDocument, len(doc))
+	for i, dep := range doc {
+		retVal[i] = newCachedSentence(dep.AnnotatedSentence)
+	}
+	return retVal
+}
+
+func (c cachedDocument) document() Document {
+	retVal := make(Document, len(c))
+	for i, s := range c {
+		retVal[i] = s.dependency()
+	}
+	return retVal
+}
+
+func newCachedSentence(s lingo.AnnotatedSentence) cachedSentence {
+	idx := make(map[*lingo.Annotation]int)
+	for i, a := range s {
+		idx[a] = i
+	}
+
+	retVal := make(cachedSentence, len(s))
+	for i, a := range s {
+		head := -1
+		if a.Head != nil {
+			if h, ok := idx[a.Head]; ok {
+				head = h
+			}
+		}
+		retVal[i] = cachedAnnotation{
+			Value:          a.Value,
+			LexemeType:     a.LexemeType,
+			POSTag:         a.POSTag,
+			DependencyType: a.DependencyType,
+			Lemma:          a.Lemma,
+			Lowered:        a.Lowered,
+			Stem:           a.Stem,
+			Head:           head,
+		}
+	}
+	return retVal
+}
+
+func (c cachedSentence) dependency() *lingo.Dependency {
+	s := make(lingo.AnnotatedSentence, len(c))
+	for i, a := range c {
+		if i == 0 {
+			s[i] = lingo.RootAnnotation()
+			continue
+		}
+		ann := lingo.NewAnnotation()
+		ann.Value = a.Value
+		ann.LexemeType = a.LexemeType
+		ann.POSTag = a.POSTag
+		ann.DependencyType = a.DependencyType
+		ann.Lemma = a.Lemma
+		ann.Lowered = a.Lowered
+		ann.Stem = a.Stem
+		s[i] = ann
+	}
+	for i, a := range c {
+		if a.Head >= 0 && a.Head < len(s) {
+			s[i].Head = s[a.Head]
+		}
+	}
+	return lingo.NewDependency(lingo.FromAnnotatedSentence(s))
+}
+
+// fingerprint hashes the contents of the named files. Files that do not exist are skipped.
+func fingerprint(names ...string) ([]byte, error) {
+	h := sha256.New()
+	for _, name := range names {
+		f, err := os.Open(name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		io.WriteString(h, name)
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "Unable to fingerprint %v", name)
+		}
+	}
+	return h.Sum(nil), nil
+}
+
+// cacheKey is derived from the file's path, its contents and the models used to parse it.
+func cacheKey(name string, content []byte) string {
+	h := sha256.New()
+	io.WriteString(h, cacheVersion)
+	io.WriteString(h, name)
+	sum := sha256.Sum256(content)
+	h.Write(sum[:])
+	h.Write(modelFingerprint)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// cachedParse parses the named file, using the parse cache if one is configured.
+func cachedParse(name string) (doc Document, err error) {
+	var content []byte
+	if content, err = ioutil.ReadFile(name); err != nil {
+		return
+	}
+	if parseCacheLoc == "" {
+		return pipeline(name, bytes.NewReader(content))
+	}
+	if err = loadFingerprint(); err != nil {
+		return
+	}
+
+	loc := filepath.Join(parseCacheLoc, cacheKey(name, content)+".gob")
+	if doc, err = readCached(loc); err == nil {
+		return doc, nil
+	}
+
+	if doc, err = pipeline(name, bytes.NewReader(content)); err != nil {
+		return
+	}
+	if err := writeCached(loc, doc); err != nil {
+		log.Printf("Unable to cache the parse of %v: %v", name, err)
+	}
+	return doc, nil
+}
+
+func readCached(loc string) (Document, error) {
+	f, err := os.Open(loc)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var c cachedDocument
+	if err = gob.NewDecoder(f).Decode(&c); err != nil {
+		return nil, err
+	}
+	return c.document(), nil
+}
+
+func writeCached(loc string, doc Document) (err error) {
+	if err = os.MkdirAll(filepath.Dir(loc), 0755); err != nil {
+		return
+	}
+
+	// concurrent loaders may write the same entry, so write to a unique temporary file first
+	var f *os.File
+	if f, err = ioutil.TempFile(filepath.Dir(loc), "tmp"); err != nil {
+		return
+	}
+	if err = gob.NewEncoder(f).Encode(newCachedDocument(doc)); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return
+	}
+	if err = f.Close(); err != nil {
+		os.Remove(f.Name())
+		return
+	}
+	return os.Rename(f.Name(), loc)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io"
+	"os"
+
+	. "github.com/chewxy/gorgonia"
+	"github.com/chewxy/gorgonia/tensor"
+	"github.com/chewxy/lingo/corpus"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	gob.Register([]float32{})
+	gob.Register([]float64{})
+}
+
+// param is a serialized learnable. Data is the backing slice of the value.
+type param struct {
+	Name  string
+	Shape tensor.Shape
+	Data  interface{}
+}
+
+// checkpoint is what gets written by (*Model).Save. It holds everything required to rebuild the model.
+type checkpoint struct {
+	Dtype    string
+	EmbShape tensor.Shape
+	Spec     Spec
+	Labels   []string
+
+	Corpus       *corpus.Corpus
+	Params       []param
+	ClassWeights []float64 // weight of each label in the cost. See (*Model).SetClassWeights
+}
+
+// Save writes the model's configuration, its corpus and every learnable to w.
+func (m *Model) Save(w io.Writer) error {
+	ckpt := checkpoint{
+		Dtype:    m.t.String(),
+		EmbShape: m.emb.Shape(),
+		Spec:     m.spec,
+		Labels:   m.labels,
+		Corpus:   m.c,
+
+		ClassWeights: m.ClassWeights(),
+	}
+
+	for _, n := range m.Learnables() {
+		v := n.Value()
+		if v == nil {
+			return errors.Errorf("Learnable %v has no value", n.Name())
+		}
+		ckpt.Params = append(ckpt.Params, param{
+			Name:  n.Name(),
+			Shape: v.Shape(),
+			Data:  v.Data(),
+		})
+	}
+
+	if err := gob.NewEncoder(w).Encode(ckpt); err != nil {
+		return errors.Wrap(err, "Unable to encode model")
+	}
+	return nil
+}
+
+// LoadModel reads a model written by (*Model).Save. The model is compiled to predict only, one example at a time.
+func LoadModel(r io.Reader) (m *Model, err error) { return decodeModel(r, 1, false) }
+
+// decodeModel reads a model written by (*Model).Save, and compiles it to train on batch examples at a time
+// if train is true. Otherwise it is compiled to predict only.
+func decodeModel(r io.Reader, batch int, train bool) (m *Model, err error) {
+	var ckpt checkpoint
+	if err = gob.NewDecoder(r).Decode(&ckpt); err != nil {
+		return nil, errors.Wrap(err, "Unable to decode model")
+	}
+
+	var t tensor.Dtype
+	if t, err = dtypeOf(ckpt.Dtype); err != nil {
+		return nil, err
+	}
+
+	if m, err = newModel(ckpt.EmbShape, t, ckpt.Spec, ckpt.Labels, batch, train); err != nil {
+		return nil, err
+	}
+	m.c = ckpt.Corpus
+
+	params := make(map[string]*Node)
+	for _, n := range m.Learnables() {
+		params[n.Name()] = n
+	}
+
+	for _, p := range ckpt.Params {
+		n, ok := params[p.Name]
+		if !ok {
+			return nil, errors.Errorf("Unknown learnable %q in checkpoint", p.Name)
+		}
+
+		if !n.Shape().Eq(p.Shape) {
+			return nil, errors.Errorf("Learnable %q has shape %v in the checkpoint. Expected %v", p.Name, p.Shape, n.Shape())
+		}
+
+		T := tensor.New(tensor.WithShape(p.Shape...), tensor.WithBacking(p.Data))
+		if T.Dtype() != t {
+			return nil, errors.Errorf("Learnable %q has dtype %v in the checkpoint. Expected %v", p.Name, T.Dtype(), t)
+		}
+
+		if err = Let(n, T); err != nil {
+			return nil, errors.Wrapf(err, "Unable to set %q", p.Name)
+		}
+		delete(params, p.Name)
+	}
+
+	for name := range params {
+		return nil, errors.Errorf("Learnable %q is missing from the checkpoint", name)
+	}
+
+	if ckpt.ClassWeights != nil {
+		if err = m.SetClassWeights(ckpt.ClassWeights); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func saveModel(name string, m *Model) (err error) {
+	var f *os.File
+	if f, err = os.Create(name); err != nil {
+		return
+	}
+	if err = m.Save(f); err != nil {
+		f.Close()
+		return
+	}
+	return f.Close()
+}
+
+func loadModel(name string) (m *Model, err error) {
+	var f *os.File
+	if f, err = os.Open(name); err != nil {
+		return
+	}
+	defer f.Close()
+	return LoadModel(f)
+}
+
+func dtypeOf(name string) (tensor.Dtype, error) {
+	switch name {
+	case Float32.String():
+		return Float32, nil
+	case Float64.String():
+		return Float64, nil
+	}
+	return Float, errors.Errorf("Unsupported dtype %q", name)
+}
+
+// trainCheckpoint is a snapshot of a training run, from which training can be resumed.
+type trainCheckpoint struct {
+	Model  []byte
+	Solver *adaGradSolver
+	State  *trainState
+}
+
+func saveCheckpoint(name string, m *Model, solver *adaGradSolver, st *trainState) (err error) {
+	var buf bytes.Buffer
+	if err = m.Save(&buf); err != nil {
+		return
+	}
+
+	ckpt := trainCheckpoint{
+		Model:  buf.Bytes(),
+		Solver: solver,
+		State:  st,
+	}
+
+	// write to a temporary file first so that a crash mid-write doesn't clobber the previous checkpoint
+	tmp := name + ".tmp"
+	var f *os.File
+	if f, err = os.Create(tmp); err != nil {
+		return
+	}
+	if err = gob.NewEncoder(f).Encode(ckpt); err != nil {
+		f.Close()
+		return errors.Wrap(err, "Unable to encode checkpoint")
+	}
+	if err = f.Close(); err != nil {
+		return
+	}
+	return os.Rename(tmp, name)
+}
+
+// loadCheckpoint reads a training checkpoint. The model is compiled to train on batch examples at a time.
+func loadCheckpoint(name string, batch int) (m *Model, solver *adaGradSolver, st *trainState, err error) {
+	var f *os.File
+	if f, err = os.Open(name); err != nil {
+		return
+	}
+	defer f.Close()
+
+	var ckpt trainCheckpoint
+	if err = gob.NewDecoder(f).Decode(&ckpt); err != nil {
+		err = errors.Wrap(err, "Unable to decode checkpoint")
+		return
+	}
+	if m, err = decodeModel(bytes.NewReader(ckpt.Model), batch, true); err != nil {
+		return
+	}
+	return m, ckpt.Solver, ckpt.State, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestSaveLoadModel(t *testing.T) {
+	hierarchical := testSpec()
+	hierarchical.Cell = lstmCell
+	hierarchical.Bidirectional = true
+	hierarchical.Sentences = 2
+
+	head := testSpec()
+	head.Attention = scalarAttention
+	head.Head = HeadSpec{
+		Hidden: []DenseSpec{{Size: 4, Activation: reluAct}},
+		Bias:   true,
+		Output: sigmoidOutput,
+	}
+
+	specs := []struct {
+		name string
+		spec Spec
+	}{
+		{"default", testSpec()},
+		{"hierarchical", hierarchical},
+		{"head", head},
+	}
+
+	doc := testDoc("the senate passed a bill on tuesday", "critics said it fails voters")
+	for _, tc := range specs {
+		m := newTestModel(t, tc.spec, 2)
+		if err := m.SetClassWeights([]float64{0.5, 1, 2}); err != nil {
+			t.Fatal(err)
+		}
+		want, err := m.PredictProba(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		if err = m.Save(&buf); err != nil {
+			t.Fatalf("%v: %v", tc.name, err)
+		}
+		loaded, err := LoadModel(&buf)
+		if err != nil {
+			t.Fatalf("%v: %v", tc.name, err)
+		}
+
+		if !reflect.DeepEqual(loaded.Spec(), m.Spec()) {
+			t.Errorf("%v: loaded spec %+v. Expected %+v", tc.name, loaded.Spec(), m.Spec())
+		}
+		if !reflect.DeepEqual(loaded.Labels(), m.Labels()) {
+			t.Errorf("%v: loaded labels %v. Expected %v", tc.name, loaded.Labels(), m.Labels())
+		}
+		if !reflect.DeepEqual(loaded.ClassWeights(), m.ClassWeights()) {
+			t.Errorf("%v: loaded class weights %v. Expected %v", tc.name, loaded.ClassWeights(), m.ClassWeights())
+		}
+		if loaded.c.Size() != m.c.Size() {
+			t.Errorf("%v: loaded corpus has %d words. Expected %d", tc.name, loaded.c.Size(), m.c.Size())
+		}
+
+		theirs := loaded.Learnables()
+		for i, n := range m.Learnables() {
+			if !reflect.DeepEqual(theirs[i].Value().Data(), n.Value().Data()) {
+				t.Errorf("%v: %v differs after loading", tc.name, n.Name())
+			}
+		}
+
+		got, err := loaded.PredictProba(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !equalProbs(got.Probs, want.Probs) {
+			t.Errorf("%v: loaded model predicts %v. Expected %v", tc.name, got.Probs, want.Probs)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/chewxy/gorgonia/tensor"
+	"github.com/pkg/errors"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{"train", "train a model on a corpus", runTrain},
+	{"eval", "evaluate a saved model on a corpus", runEval},
+	{"predict", "classify files, or stdin, with a saved model", runPredict},
+	{"repl", "classify sentences interactively with a saved model", runREPL},
+	{"serve", "serve predictions of a saved model over HTTP", runServe},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "\t%-8s %s\n", cmd.name, cmd.usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun %s <command> -h to see the flags of a command\n", os.Args[0])
+}
+
+// dispatch runs the command named by the first argument.
+// For compatibility, arguments that start with a flag are passed to train.
+func dispatch(args []string) error {
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	name := args[0]
+	if len(name) > 0 && name[0] == '-' && name != "-h" && name != "-help" {
+		return runTrain(args)
+	}
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd.run(args[1:])
+		}
+	}
+
+	usage()
+	os.Exit(2)
+	return nil
+}
+
+// loadSavedModel loads the NLP models and the saved model. The labels are set to those of the saved model.
+func loadSavedModel(modelLoc string) (m *Model, err error) {
+	if modelLoc == "" {
+		return nil, errors.New("A saved model is required. Use -model")
+	}
+	if err = loadModels(); err != nil {
+		return
+	}
+	if m, err = loadModel(modelLoc); err != nil {
+		return
+	}
+	labels = m.Labels()
+	return
+}
+
+func runEval(args []string) (err error) {
+	fs := flag.NewFlagSet("eval", flag.ExitOnError)
+	modelLoc := fs.String("model", "", "Location of the saved model")
+	set := fs.String("set", "test", "Examples to evaluate on: train, validate, test or all")
+	verbose := fs.Bool("v", false, "List the misclassified examples")
+	nlpFlags(fs)
+	corpusFlags(fs)
+	splitFlags(fs)
+	fs.Parse(args)
+
+	var m *Model
+	if m, err = loadSavedModel(*modelLoc); err != nil {
+		return
+	}
+
+	var all []example
+	if all, err = loadExamples(m.spec.multiLabel()); err != nil {
+		return
+	}
+
+	var exs []example
+	if *set == "all" {
+		exs = all
+	} else {
+		var train, validate, test []example
+		if train, validate, test, err = splitExamples(all); err != nil {
+			return
+		}
+		switch *set {
+		case "train":
+			exs = train
+		case "validate":
+			exs = validate
+		case "test":
+			exs = test
+		default:
+			return errors.Errorf("Unknown set %q", *set)
+		}
+	}
+
+	exs = m.admissible(exs)
+	var preds []Prediction
+	if preds, err = predictAll(m, exs); err != nil {
+		return
+	}
+
+	var acc, f1 float64
+	var con tensor.Tensor
+	if acc, f1, con, err = scorePredictions(preds, exs, len(labels)); err != nil {
+		return
+	}
+	log.Printf("%s | %d examples | %f | %f\n", *set, len(exs), acc, f1)
+	fmt.Printf("%+v\n", con)
+
+	if *verbose {
+		for i, p := range preds {
+			if !exs[i].hasLabel(p.Target) {
+				fmt.Printf("%s\t%s\t%s\t%.4f\t%.4f\n", exs[i].name, exs[i].target, p.Label, p.Confidence, p.Margin)
+			}
+		}
+	}
+	return nil
+}
+
+func runPredict(args []string) (err error) {
+	fs := flag.NewFlagSet("predict", flag.ExitOnError)
+	modelLoc := fs.String("model", "", "Location of the saved model")
+	explain := fs.String("explain", "", "Show the attention paid to each word: terminal or html. html writes a page to stdout")
+	samples := fs.Int("samples", 0, "Run each text through the network this many times with dropout, and report how much the runs disagree. 0 predicts once, without dropout. Otherwise at least 2")
+	nlpFlags(fs)
+	fs.Parse(args)
+
+	switch *explain {
+	case "", "terminal", "html":
+	default:
+		return errors.Errorf("Unknown explanation format %q", *explain)
+	}
+	if err = validSamples(*samples); err != nil {
+		return
+	}
+
+	var m *Model
+	if m, err = loadSavedModel(*modelLoc); err != nil {
+		return
+	}
+
+	names := fs.Args()
+	if len(names) == 0 {
+		names = []string{"-"} // stdin
+	}
+
+	preds := make([]Prediction, len(names))
+	for i, name := range names {
+		var b []byte
+		if name == "-" {
+			b, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			b, err = ioutil.ReadFile(name)
+		}
+		if err != nil {
+			return
+		}
+
+		if preds[i], err = predictText(m, string(b), *samples); err != nil {
+			return errors.Wrapf(err, "Unable to classify %v", name)
+		}
+
+		switch *explain {
+		case "":
+			label := preds[i].Label
+			if m.spec.multiLabel() {
+				label = strings.Join(preds[i].Labels, ",")
+			}
+			if u := preds[i].Uncertainty; u != nil {
+				fmt.Printf("%s\t%s\t%.4f\t%.4f\t%.4f\n", name, label, preds[i].Confidence, u.Entropy, u.Variance[preds[i].Target])
+				break
+			}
+			fmt.Printf("%s\t%s\t%.4f\n", name, label, preds[i].Confidence)
+		case "terminal":
+			fmt.Printf("%s\t", name)
+			if err = renderTerminal(os.Stdout, preds[i]); err != nil {
+				return
+			}
+		}
+	}
+
+	if *explain == "html" {
+		return renderHTML(os.Stdout, names, preds)
+	}
+	return nil
+}
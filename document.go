@@ -0,0 +1,201 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	"github.com/chewxy/lingo"
+)
+
+const (
+	sequentialDoc   = "sequential"
+	hierarchicalDoc = "hierarchical"
+)
+
+// over-length policies
+const (
+	keepHead      = "head"
+	keepTail      = "tail"
+	slidingWindow = "window"
+	rejectLong    = "reject"
+)
+
+// Document is the parse of each sentence of a text, in order.
+type Document []*lingo.Dependency
+
+// Words returns the words of every sentence, in order. The ROOT of each sentence is left out.
+func (d Document) Words() lingo.AnnotatedSentence {
+	var retVal lingo.AnnotatedSentence
+	for _, s := range d.sentences() {
+		retVal = append(retVal, s...)
+	}
+	return retVal
+}
+
+// sentences returns the words of each sentence, without its ROOT.
+func (d Document) sentences() []lingo.AnnotatedSentence {
+	retVal := make([]lingo.AnnotatedSentence, 0, len(d))
+	for _, dep := range d {
+		if len(dep.AnnotatedSentence) == 0 {
+			continue
+		}
+		retVal = append(retVal, dep.AnnotatedSentence[1:])
+	}
+	return retVal
+}
+
+func (d Document) SprintRel() string {
+	rels := make([]string, len(d))
+	for i, dep := range d {
+		rels[i] = dep.SprintRel()
+	}
+	return strings.Join(rels, "\n")
+}
+
+// views lays the words of a document out into the rows they are bound to in a slot.
+// A sequential model reads the whole document as one row, so it reads at most q words of the whole document:
+// Spec.MaxWords, or Spec.MaxQuery if that is not set. A hierarchical model reads each sentence as a row,
+// of at most q words, and reads at most m.sents rows. What happens to a document
+// that does not fit depends on the over-length policy:
+//
+//	head   keeps the first words of each row, and the first rows
+//	tail   keeps the last words of each row, and the last rows
+//	window reads overlapping windows of the document. Each window is a view of its own
+//	reject returns an OverLengthError
+//
+// Every policy but window returns exactly one view.
+func (m *Model) views(doc Document) (views [][]lingo.AnnotatedSentence, err error) {
+	var rows []lingo.AnnotatedSentence
+	maxRows := 1
+	if m.sents == 0 {
+		rows = []lingo.AnnotatedSentence{doc.Words()}
+	} else {
+		rows = doc.sentences()
+		maxRows = m.sents
+	}
+
+	switch m.spec.Overlength {
+	case keepTail:
+		if len(rows) > maxRows {
+			rows = rows[len(rows)-maxRows:]
+		}
+		for i, row := range rows {
+			if len(row) > m.q {
+				rows[i] = row[len(row)-m.q:]
+			}
+		}
+	case slidingWindow:
+		var split []lingo.AnnotatedSentence
+		for _, row := range rows {
+			for _, w := range windows(len(row), m.q) {
+				split = append(split, row[w[0]:w[1]])
+			}
+		}
+		for _, w := range windows(len(split), maxRows) {
+			views = append(views, split[w[0]:w[1]])
+		}
+		return views, nil
+	case rejectLong:
+		if len(rows) > maxRows {
+			return nil, OverLengthError{"sentences", len(rows), maxRows}
+		}
+		for _, row := range rows {
+			if len(row) > m.q {
+				return nil, OverLengthError{"words", len(row), m.q}
+			}
+		}
+	default:
+		if len(rows) > maxRows {
+			rows = rows[:maxRows]
+		}
+		for i, row := range rows {
+			if len(row) > m.q {
+				rows[i] = row[:m.q]
+			}
+		}
+	}
+	return [][]lingo.AnnotatedSentence{rows}, nil
+}
+
+// windows covers n items with windows of size items. Consecutive windows overlap by half.
+func windows(n, size int) [][2]int {
+	if n <= size {
+		return [][2]int{{0, n}}
+	}
+
+	stride := size / 2
+	if stride < 1 {
+		stride = 1
+	}
+	var retVal [][2]int
+	for start := 0; start+size < n; start += stride {
+		retVal = append(retVal, [2]int{start, start + size})
+	}
+	return append(retVal, [2]int{n - size, n})
+}
+
+// fits reports whether the model reads every word of the document in a single view.
+func (m *Model) fits(doc Document) bool {
+	if m.sents == 0 {
+		return len(doc.Words()) <= m.q
+	}
+	sentences := doc.sentences()
+	if len(sentences) > m.sents {
+		return false
+	}
+	for _, s := range sentences {
+		if len(s) > m.q {
+			return false
+		}
+	}
+	return true
+}
+
+// logTruncated reports how many of the examples are cut short by the head or tail policies.
+func (m *Model) logTruncated(exs []example) {
+	if m.spec.Overlength != keepHead && m.spec.Overlength != keepTail {
+		return
+	}
+	var n int
+	for _, ex := range exs {
+		if !m.fits(ex.doc) {
+			n++
+		}
+	}
+	if n > 0 {
+		log.Printf("%d of %d documents do not fit in the model, and are truncated to their %s", n, len(exs), m.spec.Overlength)
+	}
+}
+
+// trainingViews drops the examples rejected by the over-length policy.
+// Under the window policy every window of a document is trained on as an example of its own.
+func (m *Model) trainingViews(exs []example) []example {
+	m.logTruncated(exs)
+	retVal := make([]example, 0, len(exs))
+	for _, ex := range exs {
+		views, err := m.views(ex.doc)
+		if err != nil {
+			log.Printf("Skipping %v: %v", ex.name, err)
+			continue
+		}
+		for i := range views {
+			ex.view = i
+			retVal = append(retVal, ex)
+		}
+	}
+	return retVal
+}
+
+// admissible drops the examples rejected by the over-length policy.
+func (m *Model) admissible(exs []example) []example {
+	m.logTruncated(exs)
+	retVal := make([]example, 0, len(exs))
+	for _, ex := range exs {
+		if _, err := m.views(ex.doc); err != nil {
+			log.Printf("Skipping %v: %v", ex.name, err)
+			continue
+		}
+		retVal = append(retVal, ex)
+	}
+	return retVal
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/chewxy/lingo"
+)
+
+func TestWindows(t *testing.T) {
+	cases := []struct {
+		n, size int
+		want    [][2]int
+	}{
+		{3, 5, [][2]int{{0, 3}}},
+		{5, 5, [][2]int{{0, 5}}},
+		{6, 4, [][2]int{{0, 4}, {2, 6}}},
+		{7, 4, [][2]int{{0, 4}, {2, 6}, {3, 7}}},
+		{10, 4, [][2]int{{0, 4}, {2, 6}, {4, 8}, {6, 10}}},
+		{3, 1, [][2]int{{0, 1}, {1, 2}, {2, 3}}},
+	}
+
+	for _, tc := range cases {
+		if got := windows(tc.n, tc.size); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("windows(%d, %d) = %v. Expected %v", tc.n, tc.size, got, tc.want)
+		}
+	}
+}
+
+// viewWords returns the words of each row of each view.
+func viewWords(views [][]lingo.AnnotatedSentence) [][][]string {
+	retVal := make([][][]string, len(views))
+	for i, rows := range views {
+		retVal[i] = make([][]string, len(rows))
+		for j, row := range rows {
+			for _, a := range row {
+				retVal[i][j] = append(retVal[i][j], a.Value)
+			}
+		}
+	}
+	return retVal
+}
+
+func TestViews(t *testing.T) {
+	long := testDoc("a b c", "d e f")
+	sentences := testDoc("a b c", "d", "e f")
+
+	cases := []struct {
+		name       string
+		sents, q   int
+		overlength string
+		doc        Document
+		fits       bool
+		want       [][][]string
+		err        error
+	}{
+		{"sequential fits", 0, 4, keepHead, testDoc("a b"), true, [][][]string{{{"a", "b"}}}, nil},
+		{"sequential head", 0, 4, keepHead, long, false, [][][]string{{{"a", "b", "c", "d"}}}, nil},
+		{"sequential tail", 0, 4, keepTail, long, false, [][][]string{{{"c", "d", "e", "f"}}}, nil},
+		{"sequential window", 0, 4, slidingWindow, long, false, [][][]string{{{"a", "b", "c", "d"}}, {{"c", "d", "e", "f"}}}, nil},
+		{"sequential reject", 0, 4, rejectLong, long, false, nil, OverLengthError{"words", 6, 4}},
+
+		{"hierarchical head", 2, 2, keepHead, sentences, false, [][][]string{{{"a", "b"}, {"d"}}}, nil},
+		{"hierarchical tail", 2, 2, keepTail, sentences, false, [][][]string{{{"d"}, {"e", "f"}}}, nil},
+		{"hierarchical window", 2, 2, slidingWindow, sentences, false, [][][]string{
+			{{"a", "b"}, {"b", "c"}},
+			{{"b", "c"}, {"d"}},
+			{{"d"}, {"e", "f"}},
+		}, nil},
+		{"hierarchical reject sentences", 2, 2, rejectLong, sentences, false, nil, OverLengthError{"sentences", 3, 2}},
+		{"hierarchical reject words", 3, 2, rejectLong, sentences, false, nil, OverLengthError{"words", 3, 2}},
+	}
+
+	for _, tc := range cases {
+		m := &Model{q: tc.q, sents: tc.sents, spec: Spec{Overlength: tc.overlength}}
+		views, err := m.views(tc.doc)
+		if err != tc.err {
+			t.Errorf("%v: error %v. Expected %v", tc.name, err, tc.err)
+			continue
+		}
+		if tc.err == nil {
+			if got := viewWords(views); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("%v: views %v. Expected %v", tc.name, got, tc.want)
+			}
+		}
+		if got := m.fits(tc.doc); got != tc.fits {
+			t.Errorf("%v: fits is %v. Expected %v", tc.name, got, tc.fits)
+		}
+	}
+}
@@ -11,6 +11,27 @@ type nocomp string
 func (e nocomp) Error() string     { return fmt.Sprintf("no %v", string(e)) }
 func (e nocomp) Component() string { return string(e) }
 
+// multiError collects the errors of concurrent work.
+type multiError []error
+
+func (e multiError) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	return fmt.Sprintf("%d errors. First error: %v", len(e), e[0])
+}
+
+// OverLengthError is returned for a document that does not fit in the model, when the over-length policy is to reject it.
+type OverLengthError struct {
+	Unit   string // "words" or "sentences"
+	Length int
+	Max    int
+}
+
+func (e OverLengthError) Error() string {
+	return fmt.Sprintf("%d %s exceeds the maximum of %d", e.Length, e.Unit, e.Max)
+}
+
 type contextualError interface {
 	Node() *gorgonia.Node
 	Value() gorgonia.Value
@@ -1,130 +1,350 @@
 package main
 
 import (
+	"bufio"
+	"io/ioutil"
 	"log"
+	"math"
+	"math/rand"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 
-	"github.com/chewxy/lingo"
+	"github.com/pkg/errors"
 )
 
+// progressEvery is how often loading progress is reported, in files.
+const progressEvery = 100
+
+// Target is the index of a label.
 type Target int
 
-const (
-	Neutral Target = iota
-	Liberal
-	Conservative
-	MAXTARGETS
-)
+// labels are the names of the targets. They are the names of the corpus' subdirectories, or are read from a label file.
+var labels []string
+
+// reservedDirs are subdirectories of the corpus that never hold examples.
+var reservedDirs = map[string]bool{
+	"shared":     true,
+	"parsecache": true,
+}
 
 func (t Target) String() string {
-	switch t {
-	case Neutral:
-		return "Neutral"
-	case Liberal:
-		return "Liberal"
-	case Conservative:
-		return "Conservative"
-	default:
-		return "UNKNOWN"
+	if int(t) >= 0 && int(t) < len(labels) {
+		return labels[t]
 	}
+	return "UNKNOWN"
+}
+
+// loadLabels reads the labels from the label file if one is given. Otherwise every subdirectory of
+// the corpus that has .txt files in it is a label.
+func loadLabels() (retVal []string, err error) {
+	if labelsLoc != "" {
+		var f *os.File
+		if f, err = os.Open(labelsLoc); err != nil {
+			return
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			label := strings.TrimSpace(scanner.Text())
+			if label == "" || strings.HasPrefix(label, "#") {
+				continue
+			}
+			retVal = append(retVal, label)
+		}
+		if err = scanner.Err(); err != nil {
+			return
+		}
+	} else {
+		var infos []os.FileInfo
+		if infos, err = ioutil.ReadDir(corpusLoc); err != nil {
+			return
+		}
+		for _, info := range infos {
+			if !info.IsDir() || reservedDirs[info.Name()] {
+				continue
+			}
+			var txts []string
+			if txts, err = filepath.Glob(filepath.Join(corpusLoc, info.Name(), "*.txt")); err != nil {
+				return
+			}
+			if len(txts) > 0 {
+				retVal = append(retVal, info.Name())
+			}
+		}
+	}
+
+	if len(retVal) < 2 {
+		return nil, errors.Errorf("Expected at least 2 labels. Got %v", retVal)
+	}
+	return
 }
 
 type example struct {
-	dep    *lingo.Dependency
-	target Target
+	name    string // file the example was parsed from
+	doc     Document
+	target  Target
+	targets []Target // every label of a multi-label example, starting with target. nil otherwise
+	view    int      // view of the document to train on. See (*Model).views
+}
+
+// labels returns every label of the example.
+func (ex example) labels() []Target {
+	if ex.targets != nil {
+		return ex.targets
+	}
+	return []Target{ex.target}
+}
+
+// hasLabel reports whether t is one of the labels of the example.
+func (ex example) hasLabel(t Target) bool {
+	for _, l := range ex.labels() {
+		if l == t {
+			return true
+		}
+	}
+	return false
 }
 
 var examples []example
 var validates []example
+var tests []example
 
-func loadExamples() (err error) {
-	var ns, ls, cs []string
-	if ns, err = filepath.Glob("model/neutral/*.txt"); err != nil {
-		return
+// loadExamples parses every file of every label in the corpus. The examples are grouped by label.
+// If multi is true, files with the same name under several labels are one example, tagged with all of those labels.
+// It is parsed from the file under its first label.
+func loadExamples(multi bool) (all []example, err error) {
+	var names []string
+	var targets []Target
+	var extra [][]Target
+	seen := make(map[string]int)
+	for i, label := range labels {
+		var files []string
+		if files, err = filepath.Glob(filepath.Join(corpusLoc, label, "*.txt")); err != nil {
+			return
+		}
+		for _, name := range files {
+			if j, ok := seen[filepath.Base(name)]; ok && multi {
+				extra[j] = append(extra[j], Target(i))
+				continue
+			}
+			seen[filepath.Base(name)] = len(names)
+			names = append(names, name)
+			targets = append(targets, Target(i))
+			extra = append(extra, nil)
+		}
 	}
 
-	if ls, err = filepath.Glob("model/liberal/*.txt"); err != nil {
+	if all, err = loadAll(names, targets); err != nil {
 		return
 	}
+	if multi {
+		for i := range all {
+			all[i].targets = append([]Target{all[i].target}, extra[i]...)
+		}
+	}
+	return
+}
 
-	if cs, err = filepath.Glob("model/conservative/*.txt"); err != nil {
-		return
+// resampling policies
+const (
+	overSample  = "over"
+	underSample = "under"
+)
+
+// resample balances the labels of the examples. Oversampling repeats randomly chosen examples of each label until it has
+// as many as the largest label. Undersampling keeps a random subset of each label, as large as the smallest label.
+// Multi-label examples are counted under their first label. The same seed always gives the same examples.
+func resample(exs []example, how string, cats int, seed int64) (retVal []example, err error) {
+	if how == "" {
+		return exs, nil
+	}
+	if how != overSample && how != underSample {
+		return nil, errors.Errorf("Unknown resampling %q", how)
 	}
 
-	neutrals := make([]example, len(ns))
-	for i, neutral := range ns {
-		dep, err := loadOne(neutral, Neutral)
-		if err != nil {
-			return err
+	r := rand.New(rand.NewSource(seed))
+	byClass := stratify(exs, cats, r)
+	min, max := -1, 0
+	for _, class := range byClass {
+		if len(class) == 0 {
+			continue
+		}
+		if min < 0 || len(class) < min {
+			min = len(class)
+		}
+		if len(class) > max {
+			max = len(class)
 		}
-		ex := example{dep, Neutral}
+	}
+
+	for _, class := range byClass {
+		if len(class) == 0 {
+			continue
+		}
+		if how == underSample {
+			retVal = append(retVal, class[:min]...)
+			continue
+		}
+		retVal = append(retVal, class...)
+		for i := len(class); i < max; i++ {
+			retVal = append(retVal, class[r.Intn(len(class))])
+		}
+	}
+	log.Printf("Resampled %d examples to %d", len(exs), len(retVal))
+	return retVal, nil
+}
 
-		neutrals[i] = ex
+// parseClassWeights returns the weight of each label as given by opt, or nil if none is given.
+// auto weighs each label by n/(k*count), where count is the number of examples with the label,
+// n the total count over every label and k the number of labels, so that every label weighs the same in total.
+// Otherwise opt lists the weights of some labels, as in Neutral=0.5,Left=2. Unlisted labels weigh 1.
+func parseClassWeights(opt string, exs []example) (weights []float64, err error) {
+	if opt == "" {
+		return nil, nil
 	}
 
-	libs := make([]example, len(ls))
-	for i, lib := range ls {
-		dep, err := loadOne(lib, Liberal)
-		if err != nil {
-			return err
+	weights = make([]float64, len(labels))
+	for i := range weights {
+		weights[i] = 1
+	}
+
+	if opt == "auto" {
+		counts := make([]int, len(labels))
+		var n int
+		for _, ex := range exs {
+			for _, t := range ex.labels() {
+				counts[t]++
+				n++
+			}
 		}
-		ex := example{dep, Liberal}
-		libs[i] = ex
+		for i, c := range counts {
+			if c == 0 {
+				log.Printf("No training examples of %v. It weighs 1", labels[i])
+				continue
+			}
+			weights[i] = float64(n) / float64(len(labels)*c)
+		}
+		return weights, nil
 	}
 
-	cons := make([]example, len(cs))
-	for i, con := range cs {
-		dep, err := loadOne(con, Conservative)
-		if err != nil {
-			return err
+	index := make(map[string]int, len(labels))
+	for i, l := range labels {
+		index[l] = i
+	}
+	for _, kv := range strings.Split(opt, ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("Expected label=weight. Got %q", kv)
+		}
+		i, ok := index[strings.TrimSpace(parts[0])]
+		if !ok {
+			return nil, errors.Errorf("Unknown label %q in class weights", parts[0])
+		}
+		if weights[i], err = strconv.ParseFloat(strings.TrimSpace(parts[1]), 64); err != nil {
+			return nil, errors.Wrapf(err, "Invalid weight for %v", parts[0])
 		}
-		ex := example{dep, Conservative}
-		cons[i] = ex
 	}
+	return weights, nil
+}
 
-	// build up examples
-	l := int(partition * float64(len(neutrals)))
-	examples = append(examples, neutrals[:l]...)
-	validates = append(validates, neutrals[l:]...)
+// weighClasses sets the class weights of the model from the classWeights flag, counting the given training examples if need be.
+// A resumed model keeps the class weights it was saved with, so the flag must either be left out or give the same weights.
+func weighClasses(m *Model, exs []example, resumed bool) (err error) {
+	var weights []float64
+	if weights, err = parseClassWeights(classWeights, exs); err != nil || weights == nil {
+		return
+	}
 
-	l = int(partition * float64(len(libs)))
-	examples = append(examples, libs[:l]...)
-	validates = append(validates, libs[l:]...)
+	if resumed {
+		saved := m.ClassWeights()
+		for i, w := range weights {
+			if math.Abs(w-saved[i]) > 1e-6*math.Max(1, math.Abs(w)) {
+				return errors.Errorf("Checkpoint was trained with class weights %v. Got %v instead", saved, weights)
+			}
+		}
+		return nil
+	}
 
-	l = int(partition * float64(len(cons)))
-	examples = append(examples, cons[:l]...)
-	validates = append(validates, cons[l:]...)
+	log.Printf("Class weights: %v", weights)
+	return m.SetClassWeights(weights)
+}
 
-	return nil
+// loadJob is a file to be parsed into an example. i is the position of the example in the results.
+type loadJob struct {
+	i    int
+	name string
+	t    Target
 }
 
-func loadOneMultithread(name string, t Target, exChan chan example, errChan chan error, wg *sync.WaitGroup) {
-	defer wg.Done()
-	f, err := os.Open(name)
-	if err != nil {
-		errChan <- err
-		return
+type loadResult struct {
+	i   int
+	ex  example
+	err error
+}
+
+// loadAll parses the files concurrently, using at most loaders goroutines.
+// The examples are returned in the same order as the names. All errors are collected into a multiError.
+func loadAll(names []string, targets []Target) ([]example, error) {
+	jobs := make(chan loadJob)
+	results := make(chan loadResult)
+
+	n := loaders
+	if n < 1 {
+		n = 1
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go loadOneMultithread(jobs, results, &wg)
+	}
+	go func() {
+		for i, name := range names {
+			jobs <- loadJob{i, name, targets[i]}
+		}
+		close(jobs)
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	exs := make([]example, len(names))
+	var errs multiError
+	var done int
+	for r := range results {
+		done++
+		if r.err != nil {
+			errs = append(errs, r.err)
+		} else {
+			exs[r.i] = r.ex
+		}
+		if done%progressEvery == 0 || done == len(names) {
+			log.Printf("Parsed %d/%d files. %d errors", done, len(names), len(errs))
+		}
 	}
-	defer f.Close()
 
-	var dep *lingo.Dependency
-	if dep, err = pipeline(name, f); err != nil {
-		errChan <- err
-		return
+	if len(errs) > 0 {
+		return nil, errs
 	}
-	log.Printf("name: %q | %v\n", dep.ValueString(), dep.SprintRel())
-	ex := example{dep, t}
-	exChan <- ex
+	return exs, nil
 }
 
-func loadOne(name string, t Target) (dep *lingo.Dependency, err error) {
-	f, err := os.Open(name)
-	if err != nil {
-		return nil, err
+func loadOneMultithread(jobs <-chan loadJob, results chan<- loadResult, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for j := range jobs {
+		doc, err := loadOne(j.name, j.t)
+		if err != nil {
+			results <- loadResult{i: j.i, err: errors.Wrapf(err, "Unable to parse %v", j.name)}
+			continue
+		}
+		results <- loadResult{i: j.i, ex: example{name: j.name, doc: doc, target: j.t}}
 	}
-	defer f.Close()
+}
 
-	return pipeline(name, f)
+func loadOne(name string, t Target) (doc Document, err error) {
+	return cachedParse(name)
 }
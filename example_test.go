@@ -0,0 +1,90 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseClassWeights(t *testing.T) {
+	labels = testLabels
+	exs := testExamples(6, 3, 1)
+
+	cases := []struct {
+		opt  string
+		want []float64
+		ok   bool
+	}{
+		{"", nil, true},
+		{"auto", []float64{10.0 / 18, 10.0 / 9, 10.0 / 3}, true},
+		{"Neutral=0.5,Left=2", []float64{2, 0.5, 1}, true},
+		{" Right = 3 ", []float64{1, 1, 3}, true},
+		{"Centre=2", nil, false},
+		{"Left", nil, false},
+		{"Left=heavy", nil, false},
+	}
+
+	for _, tc := range cases {
+		got, err := parseClassWeights(tc.opt, exs)
+		if (err == nil) != tc.ok {
+			t.Errorf("%q: error %v. Expected an error: %v", tc.opt, err, !tc.ok)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: weights %v. Expected %v", tc.opt, got, tc.want)
+		}
+	}
+
+	// a label with no examples weighs 1
+	got, err := parseClassWeights("auto", testExamples(2, 0, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []float64{4.0 / 6, 1, 4.0 / 6}; !reflect.DeepEqual(got, want) {
+		t.Errorf("auto without Neutral examples: weights %v. Expected %v", got, want)
+	}
+}
+
+func TestResample(t *testing.T) {
+	cases := []struct {
+		how    string
+		counts []int
+		want   []int
+	}{
+		{"", []int{5, 2, 1}, []int{5, 2, 1}},
+		{overSample, []int{5, 2, 1}, []int{5, 5, 5}},
+		{underSample, []int{5, 2, 1}, []int{1, 1, 1}},
+		{overSample, []int{4, 0, 2}, []int{4, 0, 4}},
+		{underSample, []int{4, 0, 2}, []int{2, 0, 2}},
+	}
+
+	for _, tc := range cases {
+		exs := testExamples(tc.counts...)
+		got, err := resample(exs, tc.how, len(tc.counts), 1)
+		if err != nil {
+			t.Errorf("%q %v: %v", tc.how, tc.counts, err)
+			continue
+		}
+		if counts := countTargets(got, len(tc.counts)); !reflect.DeepEqual(counts, tc.want) {
+			t.Errorf("%q %v: resampled to %v. Expected %v", tc.how, tc.counts, counts, tc.want)
+		}
+
+		names := make(map[string]Target, len(exs))
+		for _, ex := range exs {
+			names[ex.name] = ex.target
+		}
+		for _, ex := range got {
+			if target, ok := names[ex.name]; !ok || target != ex.target {
+				t.Errorf("%q %v: %v is not one of the examples", tc.how, tc.counts, ex.name)
+			}
+		}
+
+		again, _ := resample(exs, tc.how, len(tc.counts), 1)
+		if !reflect.DeepEqual(exampleNames(again), exampleNames(got)) {
+			t.Errorf("%q %v: the same seed gave different examples", tc.how, tc.counts)
+		}
+	}
+
+	if _, err := resample(testExamples(2, 1), "sideways", 2, 1); err == nil {
+		t.Error("Expected an error for an unknown resampling")
+	}
+}
@@ -1,11 +1,111 @@
 package main
 
-import "flag"
+import (
+	"flag"
+	"runtime"
+)
+
+// nlp
+var (
+	posModelLoc string
+	depModelLoc string
+	clusterLoc  string
+)
+
+// corpus
+var (
+	corpusLoc     string
+	labelsLoc     string
+	loaders       int
+	parseCacheLoc string
+)
 
+// split
 var (
-	posModelLoc = flag.String("pos", "", "Location for the POSTagger Model")
-	depModelLoc = flag.String("dep", "", "Location for the Dependency Parsing Model")
-	clusterLoc  = flag.String("cluster", "", "Location for brown cluster text file")
-	cpuprofile  = flag.String("cpuprofile", "", "CPU Profile Location")
-	memprofile  = flag.String("memprofile", "", "Mem Profile Location")
+	seed        int64
+	trainRatio  float64
+	validRatio  float64
+	manifestLoc string
 )
+
+// training
+var (
+	saveLoc   string
+	epochs    int
+	batchSize int
+	workers   int
+	folds     int
+
+	docMode       string
+	maxSentences  int
+	maxWords      int
+	overlength    string
+	bidirectional bool
+	cellType      string
+	bias          bool
+	multiLabel    bool
+	lossKind      string
+	focalGamma    float64
+
+	classWeights string
+	resampling   string
+
+	checkpointLoc   string
+	checkpointEvery int
+	resumeLoc       string
+
+	specLoc string
+
+	cpuprofile string
+	memprofile string
+)
+
+func nlpFlags(fs *flag.FlagSet) {
+	fs.StringVar(&posModelLoc, "pos", "", "Location for the POSTagger Model")
+	fs.StringVar(&depModelLoc, "dep", "", "Location for the Dependency Parsing Model")
+	fs.StringVar(&clusterLoc, "cluster", "", "Location for brown cluster text file")
+}
+
+func corpusFlags(fs *flag.FlagSet) {
+	fs.StringVar(&corpusLoc, "corpus", "model", "Location of the corpus. Each subdirectory of .txt files is a label")
+	fs.StringVar(&labelsLoc, "labels", "", "Location of a file listing the labels, one per line. Each label is a subdirectory of the corpus")
+	fs.IntVar(&loaders, "loaders", runtime.NumCPU(), "Number of files to parse concurrently")
+	fs.StringVar(&parseCacheLoc, "parseCache", "model/parsecache", "Directory to cache parsed files in. Leave empty to disable the cache")
+}
+
+func splitFlags(fs *flag.FlagSet) {
+	fs.Int64Var(&seed, "seed", defaultSeed, "Seed for splitting and shuffling the examples")
+	fs.Float64Var(&trainRatio, "trainRatio", 0.7, "Proportion of each label used for training")
+	fs.Float64Var(&validRatio, "validRatio", 0.15, "Proportion of each label used for validation. The rest is used for testing")
+	fs.StringVar(&manifestLoc, "manifest", "", "Location of the split manifest. If it exists the split is read from it, otherwise the split is written to it")
+}
+
+func trainFlags(fs *flag.FlagSet) {
+	fs.StringVar(&saveLoc, "save", "", "Location to save the trained model")
+	fs.IntVar(&epochs, "epochs", 5, "Number of epochs to train for")
+	fs.IntVar(&batchSize, "batch", 1, "Number of examples per solver step")
+	fs.IntVar(&workers, "workers", 1, "Number of copies of the model to train concurrently. Each trains on its own batch")
+	fs.IntVar(&folds, "folds", 0, "Run k-fold cross validation on the training and validation sets instead of training a single model")
+
+	fs.StringVar(&specLoc, "spec", "", "Location of a JSON model spec describing the architecture. The flags below override it when they are set")
+	fs.StringVar(&docMode, "doc", sequentialDoc, "How a document is read. sequential reads its sentences one after another; hierarchical reads each sentence separately and attends over them")
+	fs.IntVar(&maxSentences, "sentences", 8, "Maximum number of sentences of a document read by a hierarchical model")
+	fs.IntVar(&maxWords, "maxWords", 0, "Maximum number of words of a document read by a sequential model. 0 reads as many words as a hierarchical model reads per sentence")
+	fs.StringVar(&cellType, "cell", gruCell, "Type of the recurrent cells: gru or lstm")
+	fs.BoolVar(&bidirectional, "bidirectional", false, "Read each sentence in both directions, so the attention at each word sees the words after it too")
+	fs.BoolVar(&bias, "bias", false, "Add a bias to the output layer")
+	fs.BoolVar(&multiLabel, "multilabel", false, "Tag each document with every label whose probability is at least 0.5, using a sigmoid output. A file found under several labels of the corpus is one example with all of them")
+	fs.StringVar(&lossKind, "loss", nllLoss, "Loss to train with: nll, or focal to focus on the examples the model gets wrong")
+	fs.Float64Var(&focalGamma, "gamma", 2, "Focusing parameter of the focal loss")
+	fs.StringVar(&overlength, "overlength", keepHead, "What to do with documents that do not fit in the model: head, tail, window or reject. window reads overlapping windows and averages their predictions")
+
+	fs.StringVar(&classWeights, "classWeights", "", "Weight of each label in the loss. auto weighs each label inversely to its frequency in the training set; otherwise a list such as Neutral=0.5,Left=2. Unlisted labels weigh 1")
+	fs.StringVar(&resampling, "resample", "", "Balance the labels of the training set. over repeats examples of the smaller labels; under drops examples of the larger labels")
+
+	fs.StringVar(&checkpointLoc, "checkpoint", "", "Location to write training checkpoints to")
+	fs.IntVar(&checkpointEvery, "checkpointEvery", 1000, "Write a checkpoint every N examples")
+	fs.StringVar(&resumeLoc, "resume", "", "Resume training from the checkpoint at this location")
+
+	fs.StringVar(&cpuprofile, "cpuprofile", "", "CPU Profile Location")
+	fs.StringVar(&memprofile, "memprofile", "", "Mem Profile Location")
+}
@@ -2,10 +2,18 @@
 
 package main
 
-import "github.com/chewxy/gorgonia/tensor"
+import (
+	"github.com/chewxy/gorgonia"
+	"github.com/chewxy/gorgonia/tensor"
+)
 
 var Float = tensor.Float32
 
+// float is the Go type backing values of dtype Float.
+type float = float32
+
 const (
 	defaultDepModelLoc = "model/shared/dep_stanfordtags_universalrel.final.model_f32"
 )
+
+func newScalar(v float64) gorgonia.Value { return gorgonia.NewF32(float32(v)) }
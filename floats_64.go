@@ -2,10 +2,18 @@
 
 package main
 
-import "github.com/chewxy/gorgonia/tensor"
+import (
+	"github.com/chewxy/gorgonia"
+	"github.com/chewxy/gorgonia/tensor"
+)
 
 var Float = tensor.Float64
 
+// float is the Go type backing values of dtype Float.
+type float = float64
+
 const (
 	defaultDepModelLoc = "model/shared/dep_stanfordtags_universalrel.final.model"
 )
+
+func newScalar(v float64) gorgonia.Value { return gorgonia.NewF64(v) }
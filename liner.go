@@ -1,32 +1,55 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/chewxy/lingo"
 	"github.com/peterh/liner"
 )
 
+const replHelp = `Type a sentence to classify it. Commands:
+	:dep           print the dependency parse of each sentence of the last input
+	:q             print the last sentence
+	:probs         print the probability of each label for the last sentence
+	:attn          print the attention paid to each word of the last sentence
+	:highlight     print the last sentence, shaded by the attention paid to each word
+	:tokens        print the tokens of the last sentence as the model sees them
+	:save-history  write the history to disk now
+	:help          print this message`
+
 type ctx struct {
 	*liner.State
 	promptStr string
+	history   string // location of the history file
 
-	q     string
-	dep   *lingo.Dependency
-	class Target
-	m     *Model
+	q    string
+	doc  Document
+	pred Prediction
+	m    *Model
 }
 
-func newCtx(m *Model) *ctx {
+func newCtx(m *Model, history string) *ctx {
 	l := liner.NewLiner()
 	l.SetCtrlCAborts(true)
-	return &ctx{
+	c := &ctx{
 		State:     l,
 		promptStr: ">>>",
+		history:   history,
 		m:         m,
 	}
+
+	if history != "" {
+		if f, err := os.Open(history); err == nil {
+			c.ReadHistory(f)
+			f.Close()
+		}
+	}
+	return c
 }
 
 func (c *ctx) Run() {
@@ -38,10 +61,34 @@ func (c *ctx) Run() {
 	}
 }
 
+// Close saves the history and restores the terminal.
+func (c *ctx) Close() error {
+	if err := c.saveHistory(); err != nil {
+		fmt.Printf("ERR: %v\n", err)
+	}
+	return c.State.Close()
+}
+
+func (c *ctx) saveHistory() (err error) {
+	if c.history == "" {
+		return nil
+	}
+
+	var f *os.File
+	if f, err = os.Create(c.history); err != nil {
+		return
+	}
+	if _, err = c.WriteHistory(f); err != nil {
+		f.Close()
+		return
+	}
+	return f.Close()
+}
+
 func (c *ctx) main() (err error) {
 	var q string
-	var dep *lingo.Dependency
-	var class Target
+	var doc Document
+	var pred Prediction
 	if q, err = c.Prompt(c.promptStr); err != nil {
 		if err == io.EOF {
 			return
@@ -55,37 +102,27 @@ func (c *ctx) main() (err error) {
 
 	c.AppendHistory(q)
 	if strings.HasPrefix(q, ":") {
-		// process commands
-		switch q {
-		case ":dep":
-			if c.dep != nil {
-				fmt.Printf("%v\n", c.dep.SprintRel())
-			} else {
-				fmt.Println("No Dependency yet")
-			}
-		case ":q":
-			fmt.Printf("%q\n", c.q)
-		}
+		c.command(q)
 		goto end
 	}
 	if q == c.q {
-		fmt.Printf("Predicted; %s\n", c.class)
+		c.printPrediction()
 		goto end
 	}
 
-	if dep, err = pipeline(q, strings.NewReader(q)); err != nil {
+	if doc, err = pipeline(q, strings.NewReader(q)); err != nil {
 		goto end
 	}
 
-	if class, err = c.m.PredPreparsed(dep); err != nil {
+	if pred, err = c.m.PredictProba(doc); err != nil {
 		goto end
 	}
 
 	// save state
 	c.q = q
-	c.dep = dep
-	c.class = class
-	fmt.Println("Predicted: %s\n", class)
+	c.doc = doc
+	c.pred = pred
+	c.printPrediction()
 
 end:
 	// Catch errors except EOF
@@ -96,3 +133,100 @@ end:
 
 	return nil
 }
+
+func (c *ctx) printPrediction() {
+	fmt.Printf("Predicted: %s (confidence %.4f, margin %.4f)\n", c.pred.Label, c.pred.Confidence, c.pred.Margin)
+}
+
+func (c *ctx) command(q string) {
+	switch q {
+	case ":help":
+		fmt.Println(replHelp)
+		return
+	case ":save-history":
+		if err := c.saveHistory(); err != nil {
+			fmt.Printf("ERR: %v\n", err)
+			return
+		}
+		fmt.Printf("History saved to %v\n", c.history)
+		return
+	case ":dep", ":q", ":probs", ":attn", ":highlight", ":tokens":
+	default:
+		fmt.Printf("Unknown command %q. Type :help for a list of commands\n", q)
+		return
+	}
+
+	if c.doc == nil {
+		fmt.Println("No sentence yet")
+		return
+	}
+
+	switch q {
+	case ":dep":
+		fmt.Printf("%v\n", c.doc.SprintRel())
+	case ":q":
+		fmt.Printf("%q\n", c.q)
+	case ":probs":
+		for i, p := range c.pred.Probs {
+			fmt.Printf("%-16s %.4f\n", Target(i), p)
+		}
+	case ":attn":
+		for _, w := range c.pred.Attention {
+			fmt.Printf("%-16s %.4f\n", w.Value, w.Weight)
+		}
+	case ":highlight":
+		if err := renderTerminal(os.Stdout, c.pred); err != nil {
+			fmt.Printf("ERR: %v\n", err)
+		}
+	case ":tokens":
+		unknown, _ := c.m.c.Id("-UNKNOWN-")
+		read := make(map[*lingo.Annotation]bool)
+		views, _ := c.m.views(c.doc)
+		for _, view := range views {
+			for _, row := range view {
+				for _, a := range row {
+					read[a] = true
+				}
+			}
+		}
+		for _, a := range c.doc.Words() {
+			id := c.m.WordID(a)
+			var note string
+			switch {
+			case !read[a]:
+				note = "(ignored: does not fit in the model)"
+			case id == unknown:
+				note = "(unknown word)"
+			}
+			fmt.Printf("%-16s %-8v %d %s\n", a.Value, a.POSTag, id, note)
+		}
+	}
+}
+
+// runREPL loads a saved model and classifies sentences typed in by the user.
+func runREPL(args []string) (err error) {
+	fs := flag.NewFlagSet("repl", flag.ExitOnError)
+	modelLoc := fs.String("model", "", "Location of the saved model")
+	history := fs.String("history", defaultHistoryLoc(), "Location of the history file")
+	nlpFlags(fs)
+	fs.Parse(args)
+
+	var m *Model
+	if m, err = loadSavedModel(*modelLoc); err != nil {
+		return
+	}
+
+	c := newCtx(m, *history)
+	defer c.Close()
+	fmt.Println(replHelp)
+	c.Run()
+	return nil
+}
+
+func defaultHistoryLoc() string {
+	home := os.Getenv("HOME")
+	if home == "" {
+		return ""
+	}
+	return filepath.Join(home, ".drongo_history")
+}
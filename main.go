@@ -10,37 +10,66 @@ import (
 
 	"github.com/chewxy/gorgonia"
 	"github.com/chewxy/gorgonia/tensor"
+	"github.com/pkg/errors"
 	"github.com/pkg/profile"
 )
 
 const (
-	MAXQUERY  = 45 // query length of 45 words is max. You can't analyze idiots like Nabokov or James Joyce but those are shitty writers anyway
-	partition = 0.85
+	MAXQUERY = 45 // query length of 45 words is max. You can't analyze idiots like Nabokov or James Joyce but those are shitty writers anyway
+
+	defaultSeed = 1337
 )
 
 func main() {
-	flag.Parse()
-	rand.Seed(1337)
-	if err := loadModels(); err != nil {
+	if err := dispatch(os.Args[1:]); err != nil {
 		log.Fatal(err)
 	}
-	if err := loadExamples(); err != nil {
-		log.Fatal(err)
+}
+
+// runTrain trains a model on the corpus, evaluates it on the test set and saves it.
+func runTrain(args []string) (err error) {
+	fs := flag.NewFlagSet("train", flag.ExitOnError)
+	nlpFlags(fs)
+	corpusFlags(fs)
+	splitFlags(fs)
+	trainFlags(fs)
+	fs.Parse(args)
+	rand.Seed(seed)
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	var spec Spec
+	if spec, err = loadSpec(specLoc, set); err != nil {
+		return
+	}
+
+	if err = loadModels(); err != nil {
+		return
+	}
+	if labels, err = loadLabels(); err != nil {
+		return
+	}
+
+	var all []example
+	if all, err = loadExamples(spec.multiLabel()); err != nil {
+		return
+	}
+	if examples, validates, tests, err = splitExamples(all); err != nil {
+		return
 	}
-	log.Printf("Everything loaded. Start training. %d examples. %d validations", len(examples), len(validates))
-	shuffleExamples(examples)
+	log.Printf("Everything loaded. Start training. %d examples. %d validations. %d tests", len(examples), len(validates), len(tests))
 
-	if *cpuprofile != "" {
-		f, err := os.Create(*cpuprofile)
-		if err != nil {
-			log.Fatal(err)
+	if cpuprofile != "" {
+		var f *os.File
+		if f, err = os.Create(cpuprofile); err != nil {
+			return
 		}
 		pprof.StartCPUProfile(f)
 		defer pprof.StopCPUProfile()
 	}
 	// write and then close the file. This file will be re-written
-	// if *memprofile != "" {
-	// 	f, err := os.Create(*memprofile)
+	// if memprofile != "" {
+	// 	f, err := os.Create(memprofile)
 	// 	if err != nil {
 	// 		log.Fatal(err)
 	// 	}
@@ -48,85 +77,215 @@ func main() {
 	// 	f.Close()
 	// 	runtime.GC()
 	// }
-	if *memprofile != "" {
+	if memprofile != "" {
 		defer profile.Start(profile.MemProfile, profile.ProfilePath(".")).Stop()
 	}
 
-	emb := depModel.WordEmbeddings()
-	m := NewModel(emb.Shape(), Float, MAXQUERY, int(MAXTARGETS))
-	m.c = depModel.Corpus()
-	m.SetEmbed(emb)
-	solver := gorgonia.NewAdaGradSolver(gorgonia.WithClip(3.0), gorgonia.WithLearnRate(0.05), gorgonia.WithL2Reg(0.000001))
-	for i := 0; i < 5; i++ {
-		var cost float64
-		var err error
-		if cost, err = Train(i, m, solver, examples); err != nil {
-			log.Fatalf("Error while training during iteration %d: %+v", i, err)
+	if folds > 1 {
+		if checkpointLoc != "" || resumeLoc != "" {
+			return errors.New("Cross validation does not support checkpoints")
 		}
+		cv := make([]example, 0, len(examples)+len(validates))
+		cv = append(cv, examples...)
+		cv = append(cv, validates...)
+		return crossValidate(spec, cv, folds)
+	}
+
+	if examples, err = resample(examples, resampling, len(labels), seed); err != nil {
+		return
+	}
 
-		acc, f1, con, err := checkAcc(m, validates)
-		if err != nil {
-			log.Fatal(err)
+	var m *Model
+	var solver *adaGradSolver
+	var st *trainState
+	if resumeLoc != "" {
+		if m, solver, st, err = loadCheckpoint(resumeLoc, batchSize); err != nil {
+			return
 		}
-		log.Printf("%d | %f | %f | %f\n", i, cost, acc, f1)
+		if !equalLabels(m.Labels(), labels) {
+			return errors.Errorf("Checkpoint was trained on labels %v. Got labels %v instead", m.Labels(), labels)
+		}
+	} else {
+		if m, err = modelFromDeps(spec); err != nil {
+			return
+		}
+		solver = newAdaGradSolver(0.05, 3.0, 0.000001)
+	}
 
-		if i%10 == 0 || i < 10 {
-			fmt.Printf("%+v\n", con)
+	if err = weighClasses(m, examples, resumeLoc != ""); err != nil {
+		return
+	}
+
+	trainingSet := m.trainingViews(examples)
+	validates = m.admissible(validates)
+	tests = m.admissible(tests)
+	if st == nil {
+		st = newTrainState(seed, len(trainingSet))
+	} else {
+		if len(st.Order) != len(trainingSet) {
+			return errors.Errorf("Checkpoint was trained on %d examples. Got %d examples instead", len(st.Order), len(trainingSet))
+		}
+		log.Printf("Resuming from epoch %d, example %d", st.Epoch, st.Next)
+	}
+
+	if err = fit(st, m, solver, trainingSet, validates); err != nil {
+		return
+	}
+
+	if len(tests) > 0 {
+		var acc, f1 float64
+		var con tensor.Tensor
+		if acc, f1, con, err = checkAcc(m, tests); err != nil {
+			return
 		}
-		shuffleExamples(examples)
+		log.Printf("test | %f | %f\n", acc, f1)
+		fmt.Printf("%+v\n", con)
 	}
-	// if *memprofile != "" {
-	// 	f, err := os.Create(*memprofile)
+
+	if saveLoc != "" {
+		if err = saveModel(saveLoc, m); err != nil {
+			return
+		}
+	}
+	// if memprofile != "" {
+	// 	f, err := os.Create(memprofile)
 	// 	if err != nil {
 	// 		log.Fatal(err)
 	// 	}
 	// 	pprof.WriteHeapProfile(f)
 	// 	f.Close()
 	// }
+	return nil
+}
+
+// modelFromDeps creates an untrained model, using the corpus and word embeddings of the dependency parser.
+func modelFromDeps(spec Spec) (m *Model, err error) {
+	emb := depModel.WordEmbeddings()
+	if m, err = NewModel(emb.Shape(), Float, spec, labels, batchSize); err != nil {
+		return
+	}
+	m.c = depModel.Corpus()
+
+	// the embeddings are trained in place, so they must not be shared with the parser
+	m.SetEmbed(emb.Clone().(tensor.Tensor))
+	return
+}
 
-	// c := newCtx(m)
-	// defer c.Close()
-	// c.Run()
+// fit trains m until epochs epochs have passed, validating at the end of each epoch.
+func fit(st *trainState, m *Model, solver *adaGradSolver, trainingSet, validationSet []example) (err error) {
+	var tr trainer = m
+	if workers > 1 {
+		if tr, err = newParallelTrainer(m, workers); err != nil {
+			return
+		}
+	}
+
+	for st.Epoch < epochs {
+		i := st.Epoch
+		var cost float64
+		if cost, err = Train(st, m, tr, solver, trainingSet); err != nil {
+			return errors.Wrapf(err, "Error while training during iteration %d", i)
+		}
 
+		var acc, f1 float64
+		var con tensor.Tensor
+		if acc, f1, con, err = checkAcc(m, validationSet); err != nil {
+			return
+		}
+		log.Printf("%d | %f | %f | %f\n", i, cost, acc, f1)
+
+		if i%10 == 0 || i < 10 {
+			fmt.Printf("%+v\n", con)
+		}
+		st.next()
+
+		if checkpointLoc != "" {
+			if err = saveCheckpoint(checkpointLoc, m, solver, st); err != nil {
+				return
+			}
+		}
+	}
+	return nil
 }
 
-func Train(epoch int, m *Model, solver gorgonia.Solver, trainingSet []example) (avgCost float64, err error) {
-	costs := make([]float64, len(trainingSet))
-	for i, ex := range trainingSet {
+// Train trains m on the remainder of the current epoch, as described by st, one batch at a time.
+// tr is either m itself or a trainer of its replicas.
+// If a checkpoint location is given, a checkpoint of m is written every checkpointEvery examples.
+func Train(st *trainState, m *Model, tr trainer, solver *adaGradSolver, trainingSet []example) (avgCost float64, err error) {
+	var costs []float64
+	for st.Next < len(st.Order) {
+		end := st.Next + tr.BatchSize()
+		if end > len(st.Order) {
+			end = len(st.Order)
+		}
+
+		exs := make([]example, 0, end-st.Next)
+		for _, i := range st.Order[st.Next:end] {
+			exs = append(exs, trainingSet[i])
+		}
+
+		// dropout draws from the global RNG
+		rand.Seed(st.dropoutSeed())
 		var cost float64
-		if cost, err = m.Train(solver, ex); err != nil {
+		if cost, err = tr.Train(solver, exs); err != nil {
 			return
 		}
-		costs[i] = cost
+		costs = append(costs, cost)
+
+		prev := st.Next
+		st.Next = end
+		if checkpointLoc != "" && checkpointEvery > 0 && st.Next/checkpointEvery > prev/checkpointEvery {
+			if err = saveCheckpoint(checkpointLoc, m, solver, st); err != nil {
+				return
+			}
+		}
 	}
 	return averageCosts(costs), nil
 }
 
 func checkAcc(m *Model, validationset []example) (acc, f1 float64, confusion tensor.Tensor, err error) {
+	var preds []Prediction
+	if preds, err = predictAll(m, validationset); err != nil {
+		return
+	}
+	return scorePredictions(preds, validationset, len(m.Labels()))
+}
+
+func predictAll(m *Model, exs []example) (preds []Prediction, err error) {
+	preds = make([]Prediction, len(exs))
+	for i, ex := range exs {
+		if preds[i], err = m.PredictProba(ex.doc); err != nil {
+			return nil, err
+		}
+	}
+	return preds, nil
+}
+
+// scorePredictions scores the predictions of the examples, in the same order.
+func scorePredictions(preds []Prediction, validationset []example, cats int) (acc, f1 float64, confusion tensor.Tensor, err error) {
 	// row == pred, col == actual
-	confusion = tensor.New(tensor.Of(tensor.Float64), tensor.WithShape(int(MAXTARGETS), int(MAXTARGETS)))
+	confusion = tensor.New(tensor.Of(tensor.Float64), tensor.WithShape(cats, cats))
 
 	var correct float64
-	for _, ex := range validationset {
-		var class Target
-		if class, err = m.PredPreparsed(ex.dep); err != nil {
-			return
-		}
-		if class == ex.target {
+	for i, ex := range validationset {
+		// a multi-label example is classified correctly if the most probable label is one of its own
+		class, actual := preds[i].Target, ex.target
+		if ex.hasLabel(class) {
 			correct++
+			actual = class
 		}
 
 		var s tensor.Tensor
 		if s, err = confusion.Slice(gorgonia.S(int(class))); err != nil {
 			return
 		}
-		s.Data().([]float64)[int(ex.target)] += 1
+		s.Data().([]float64)[int(actual)] += 1
 	}
 
 	var sumF1s float64
 	sumClasses0, _ := tensor.Sum(confusion, 0)
 	sumClasses1, _ := tensor.Sum(confusion, 1)
-	for i := Neutral; i < MAXTARGETS; i++ {
+	for i := 0; i < cats; i++ {
 		truePosI, _ := confusion.At(int(i), int(i))
 		sum1I, _ := sumClasses1.At(int(i))
 
@@ -141,16 +300,64 @@ func checkAcc(m *Model, validationset []example) (acc, f1 float64, confusion ten
 		f1 := 2 * (prec * recall) / (prec + recall - 1e-8)
 		sumF1s += f1
 	}
-	f1 = sumF1s / float64(MAXTARGETS)
+	f1 = sumF1s / float64(cats)
 	acc = correct / float64(len(validationset))
 	return
 }
 
-func shuffleExamples(a []example) {
+// trainState is the position of a training run. It is saved with checkpoints, so that a resumed run carries on where it stopped.
+// Each epoch shuffles the previous epoch's Order with an RNG seeded from Seed and Epoch, and each batch reseeds
+// the RNG that dropout draws its masks from with dropoutSeed. So no RNG state needs to be saved.
+type trainState struct {
+	Epoch int
+	Next  int // index into Order of the next example to train on
+	Seed  int64
+	Order []int // order in which the examples are visited this epoch
+}
+
+func newTrainState(seed int64, n int) *trainState {
+	st := &trainState{
+		Seed:  seed,
+		Order: make([]int, n),
+	}
+	for i := range st.Order {
+		st.Order[i] = i
+	}
+	st.shuffle()
+	return st
+}
+
+// next moves the training state to the start of the next epoch.
+func (st *trainState) next() {
+	st.Epoch++
+	st.Next = 0
+	st.shuffle()
+}
+
+// dropoutSeed seeds the dropout masks of the batch that starts at Next.
+// Every batch of a run gets its own seed, and a resumed run gets the seeds it would have had without stopping.
+func (st *trainState) dropoutSeed() int64 {
+	return st.Seed + int64(st.Epoch*len(st.Order)+st.Next)
+}
+
+func (st *trainState) shuffle() {
+	r := rand.New(rand.NewSource(st.Seed + int64(st.Epoch)))
+	for i := range st.Order {
+		j := r.Intn(i + 1)
+		st.Order[i], st.Order[j] = st.Order[j], st.Order[i]
+	}
+}
+
+func equalLabels(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
 	for i := range a {
-		j := rand.Intn(i + 1)
-		a[i], a[j] = a[j], a[i]
+		if a[i] != b[i] {
+			return false
+		}
 	}
+	return true
 }
 
 func averageCosts(a []float64) (retVal float64) {
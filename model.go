@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"io/ioutil"
 	"strings"
 
@@ -11,138 +12,322 @@ import (
 	"github.com/pkg/errors"
 )
 
-var hiddenSizes = []int{100, 30}
+// probEpsilon is the smallest probability the cost takes the log of. See (*Model).squeeze
+const probEpsilon = 1e-6
 
 type Model struct {
 	// dictionaries and the like
 	c *corpus.Corpus
 
 	// neural network
-	g   *ExprGraph
-	t   tensor.Dtype
-	emb *Node   // (n, d) matrix. n = vocabulary size; d = dims
-	l0  *Banana // (d, h0) matrices. First layer GRU
-	l1  *Banana // (h0, h1) matrices. Second layer GRU
-	a   *Attn   // (d, d) matrix. attention layer:
-	p   *Node   // (cat, d) matrixweights for softmax
+	g      *ExprGraph
+	t      tensor.Dtype
+	spec   Spec     // architecture of the network
+	q      int      // max words per row. From the spec. See (Spec).rowSize
+	sents  int      // max sentences of a document read hierarchically. 0 reads the document as one sequence. From the spec
+	labels []string // name of each target
+	cats   int      // number of targets
+
+	emb  *Node  // (n, d) matrix. n = vocabulary size; d = dims
+	fwd  []cell // recurrent layers, from the input up. Layer i is (h(i-1), h(i)), where h(-1) = d
+	back []cell // backward recurrent layers. nil unless the model is bidirectional
+	a    *Attn  // (c, c) matrix. attention layer. c = top hidden size, or twice that if the model is bidirectional
+	sa   *Attn  // (c, c) matrix. sentence attention layer. nil unless the model is hierarchical
+	head []*FC  // dense layers between the context and the softmax, from the context up
+	p    *Node  // (cat, c) matrixweights for softmax. c is the size of the top dense layer if there is one
+	pb   *Node  // (cat) vector. bias of the output layer. nil unless the spec asks for one
+	ones *Node  // (cat) vector of ones
+	eps  *Node  // ε. The cost takes the log of probabilities squeezed into [ε, 1-ε]. See (*Model).squeeze
+	span *Node  // 1-2ε
+	cw   *Node  // (cat) vector. weight of each target in the cost. See (*Model).SetClassWeights
+	gm   *Node  // focusing parameter of the focal loss. nil unless the spec asks for the focal loss with a positive gamma
 
 	// dummy
-	prev0 *Node
-	prev1 *Node
+	prevs []Nodes // initial state of each layer. Constant zeroes
+
+	// compiled graph. Each slot is the network unrolled over a document, for one example of a batch
+	slots []*slot
+	eval  *slot // the network unrolled without dropout, for predictions in eval mode
+	scale *Node // 1/number of examples in the batch
+	cost  *Node
+	dense Nodes // learnables updated with dense gradients. The embedding is updated sparsely
+	zero  Value // embedding used for padding
+	vm    VM    // trains on a batch
+	pvm   VM    // predicts using the eval slot
+	svm   VM    // predicts using the first slot, with dropout
+
+	training bool // whether predictions are made with dropout. See (*Model).TrainMode
 }
 
-func NewModel(embShape tensor.Shape, t tensor.Dtype, q, cats int) *Model {
+// NewModel creates a model with the architecture described by spec, and builds and compiles its graph once.
+// batch is the number of examples the model is trained on per solver step.
+func NewModel(embShape tensor.Shape, t tensor.Dtype, spec Spec, labels []string, batch int) (*Model, error) {
+	return newModel(embShape, t, spec, labels, batch, true)
+}
+
+// newModel creates a model as NewModel does. A model that does not train can only predict:
+// its graph has no gradients, so it takes far less memory than a model that trains.
+func newModel(embShape tensor.Shape, t tensor.Dtype, spec Spec, labels []string, batch int, train bool) (*Model, error) {
+	if err := spec.validate(); err != nil {
+		return nil, err
+	}
+	if batch < 1 {
+		return nil, errors.Errorf("A model needs a batch of at least 1 example. Got %d", batch)
+	}
+
 	d := embShape[1]
+	c := spec.contextSize()
+	cats := len(labels)
 
 	g := NewGraph()
-	emb := NewMatrix(g, t, WithShape(embShape...), WithName("WordEmbedding"))
-	l0 := NewGRU("gru-0", g, d, hiddenSizes[0], t)
-	l1 := NewGRU("gru-1", g, hiddenSizes[0], hiddenSizes[1], t)
-	attn := NewAttn("attention", g, tensor.Shape{hiddenSizes[1], hiddenSizes[1]}, t)
-	p := NewMatrix(g, t, WithShape(cats, hiddenSizes[1]), WithInit(GlorotU(1)), WithName("FinalLayer"))
+	m := &Model{
+		g:      g,
+		t:      t,
+		spec:   spec,
+		q:      spec.rowSize(),
+		sents:  spec.Sentences,
+		labels: labels,
+		cats:   cats,
+
+		emb: NewMatrix(g, t, WithShape(embShape...), WithName("WordEmbedding")),
+		a:   newAttn(spec.Attention, "attention", g, c, t),
+	}
 
-	prev0 := NewVector(g, t, WithShape(hiddenSizes[0]), WithInit(Zeroes()), WithName("DummyPrev0"))
-	prev1 := NewVector(g, t, WithShape(hiddenSizes[1]), WithInit(Zeroes()), WithName("DummyPrev1"))
+	input := d
+	for i, l := range spec.Layers {
+		m.fwd = append(m.fwd, newCell(spec.Cell, fmt.Sprintf("%s-%d", spec.Cell, i), g, input, l.Hidden, t))
+		if spec.Bidirectional {
+			m.back = append(m.back, newCell(spec.Cell, fmt.Sprintf("%s-back-%d", spec.Cell, i), g, input, l.Hidden, t))
+		}
 
-	return &Model{
-		g:   g,
-		t:   t,
-		emb: emb,
-		l0:  l0,
-		l1:  l1,
-		a:   attn,
-		p:   p,
+		// the initial state is all zeroes, and is never learned
+		zero := g.Constant(tensor.New(tensor.Of(t), tensor.WithShape(l.Hidden)))
+		prev := Nodes{zero}
+		if spec.Cell == lstmCell {
+			prev = append(prev, zero)
+		}
+		m.prevs = append(m.prevs, prev)
+		input = l.Hidden
+	}
+	if spec.Sentences > 0 {
+		m.sa = newAttn(spec.Attention, "sentence-attention", g, c, t)
+	}
 
-		prev0: prev0,
-		prev1: prev1,
+	for i, l := range spec.Head.Hidden {
+		fc, err := NewFC(fmt.Sprintf("dense-%d", i), g, c, l.Size, t, l.Activation)
+		if err != nil {
+			return nil, err
+		}
+		m.head = append(m.head, fc)
+		c = l.Size
+	}
+	m.p = NewMatrix(g, t, WithShape(cats, c), WithInit(GlorotU(1)), WithName("FinalLayer"))
+	if spec.Head.Bias {
+		m.pb = NewVector(g, t, WithShape(cats), WithInit(Zeroes()), WithName("FinalBias"))
+	}
+	m.ones = g.Constant(tensor.Ones(t, cats))
+	m.eps = g.Constant(newScalar(probEpsilon))
+	m.span = g.Constant(newScalar(1 - 2*probEpsilon))
+	m.cw = NewVector(g, t, WithShape(cats), WithName("ClassWeights"))
+	if err := Let(m.cw, tensor.Ones(t, cats)); err != nil {
+		return nil, errors.Wrap(err, "Unable to set the class weights")
+	}
+	if spec.Loss.Kind == focalLoss && spec.Loss.Gamma > 0 {
+		m.gm = g.Constant(newScalar(spec.Loss.Gamma))
 	}
-}
 
-func (m *Model) SetEmbed(emb Value) {
-	Let(m.emb, emb)
+	if err := m.build(batch, train); err != nil {
+		return nil, err
+	}
+	return m, nil
 }
 
-func (m *Model) Learnables() Nodes {
-	return Nodes{
-		m.emb, m.l0.w, m.l0.wr, m.l0.wz, m.a.w, m.p, // todo: fix to use getters
+// build unrolls the network over a document for each example in a batch, and compiles the graph.
+// A model that does not train skips the cost and the gradients.
+func (m *Model) build(batch int, train bool) (err error) {
+	d := m.emb.Shape()[1]
+	m.slots = make([]*slot, batch)
+	m.zero = tensor.New(tensor.WithShape(d), tensor.WithBacking(make([]float, d)))
+
+	for i := range m.slots {
+		if m.slots[i], err = m.newSlot(fmt.Sprintf("slot%d", i), true); err != nil {
+			return errors.Wrapf(err, "Unable to build slot %d", i)
+		}
 	}
-}
+	if m.eval, err = m.newSlot("eval", false); err != nil {
+		return errors.Wrap(err, "Unable to build eval slot")
+	}
+	m.pvm = NewTapeMachine(m.g.SubgraphRoots(m.eval.prob))
+	m.svm = NewTapeMachine(m.g.SubgraphRoots(m.slots[0].prob))
 
-func (m *Model) WordID(a *lingo.Annotation) int {
-	if id, ok := m.c.Id(a.Value); ok {
-		return id
+	if !train {
+		return nil
 	}
-	id, _ := m.c.Id("-UNKNOWN-")
-	return id
+	return m.buildTraining()
 }
 
-func (m *Model) OneWord(wordID int, prev0, prev1 *Node) (h0, h1, e *Node, err error) {
-	if prev0 == nil {
-		prev0 = m.prev0
+// buildTraining sums the cost of every slot, differentiates it and compiles the training graph.
+func (m *Model) buildTraining() (err error) {
+	m.scale = NewScalar(m.g, m.t, WithName("batch.scale"))
+
+	var total *Node
+	var words Nodes
+	for _, s := range m.slots {
+		words = append(words, s.words...)
+		if total == nil {
+			total = s.cost
+			continue
+		}
+		if total, err = Add(total, s.cost); err != nil {
+			return
+		}
 	}
-	if prev1 == nil {
-		prev1 = m.prev1
+	if m.cost, err = Mul(total, m.scale); err != nil {
+		return
 	}
 
-	input := Must(Slice(m.emb, S(wordID)))
-	if h0, err = m.l0.Activate(input, prev0); err != nil {
-		return
+	for _, n := range m.Learnables() {
+		if n != m.emb {
+			m.dense = append(m.dense, n)
+		}
 	}
 
-	var dropped *Node
-	if dropped, err = Dropout(h0, 0.5); err != nil {
-		return
+	// a frozen embedding needs no gradients for its words
+	wrt := make(Nodes, 0, len(m.dense)+len(words))
+	wrt = append(wrt, m.dense...)
+	if !m.spec.FreezeEmbedding {
+		wrt = append(wrt, words...)
+	}
+	var grads Nodes
+	if grads, err = Grad(m.cost, wrt...); err != nil {
+		return errors.Wrap(err, "Unable to differentiate cost")
 	}
 
-	if h1, err = m.l1.Activate(dropped, prev1); err != nil {
-		return
+	// the eval slot shares the graph, but is never trained on
+	roots := append(Nodes{m.cost}, grads...)
+	m.vm = NewTapeMachine(m.g.SubgraphRoots(roots...), BindDualValues(wrt...))
+	return nil
+}
+
+// Labels returns the name of each target the model classifies into.
+func (m *Model) Labels() []string { return m.labels }
+
+// BatchSize is the number of examples the model trains on per solver step.
+func (m *Model) BatchSize() int { return len(m.slots) }
+
+// replicate creates a copy of the model with its own graph. The learnables of the copy share
+// their values with the model's, so any update to the model is seen by the copy. The copy of a model that
+// can only predict can only predict too.
+func (m *Model) replicate() (r *Model, err error) {
+	if r, err = newModel(m.emb.Shape(), m.t, m.spec, m.labels, m.BatchSize(), m.vm != nil); err != nil {
+		return nil, err
+	}
+	r.c = m.c
+	r.training = m.training
+	if err = Let(r.cw, m.cw.Value()); err != nil {
+		return nil, errors.Wrap(err, "Unable to share the class weights")
 	}
 
-	if e, err = m.a.Exp(h1); err != nil {
-		return
+	theirs := r.Learnables()
+	for i, n := range m.Learnables() {
+		if err = Let(theirs[i], n.Value()); err != nil {
+			return nil, errors.Wrapf(err, "Unable to share %v", n.Name())
+		}
 	}
-	return
+	return r, nil
 }
 
-func (m *Model) Fwd(s lingo.AnnotatedSentence) (prob *Node, err error) {
-	hiddens := make(Nodes, 0, len(s))
-	exps := make(Nodes, 0, len(s))
-	var runningSum *Node
+// Spec returns the architecture of the model.
+func (m *Model) Spec() Spec { return m.spec }
 
-	var prev0, prev1 *Node
-	for i, a := range s[1:] {
-		if i == 0 {
-			prev0 = m.prev0
-			prev1 = m.prev1
-		}
+// TrainMode makes predictions stochastic: dropout is applied as it is during training.
+func (m *Model) TrainMode() { m.training = true }
+
+// EvalMode makes predictions deterministic: dropout is the identity. A new model is in eval mode.
+func (m *Model) EvalMode() { m.training = false }
+
+func (m *Model) SetEmbed(emb Value) {
+	Let(m.emb, emb)
+}
+
+func (m *Model) Learnables() Nodes {
+	retVal := Nodes{m.emb}
+	for _, l := range m.fwd {
+		retVal = append(retVal, l.Learnables()...)
+	}
+	for _, l := range m.back {
+		retVal = append(retVal, l.Learnables()...)
+	}
+	retVal = append(retVal, m.a.Learnables()...)
+	if m.sa != nil {
+		retVal = append(retVal, m.sa.Learnables()...)
+	}
+	for _, l := range m.head {
+		retVal = append(retVal, l.Learnables()...)
+	}
+	retVal = append(retVal, m.p)
+	if m.pb != nil {
+		retVal = append(retVal, m.pb)
+	}
+	return retVal
+}
+
+func (m *Model) WordID(a *lingo.Annotation) int {
+	if id, ok := m.c.Id(a.Value); ok {
+		return id
+	}
+	id, _ := m.c.Id("-UNKNOWN-")
+	return id
+}
 
-		var h0, h1, e *Node
-		if h0, h1, e, err = m.OneWord(m.WordID(a), prev0, prev1); err != nil {
+// OneWord runs one step of the encoder. input is the word's embedding, and prevs the previous state of each layer.
+// The state of each layer is returned, along with the output of the top layer. Dropout is only applied if dropout is true.
+func (m *Model) OneWord(input *Node, prevs []Nodes, dropout bool) (states []Nodes, out *Node, err error) {
+	return m.step(m.fwd, input, prevs, dropout)
+}
+
+// BackWord runs one step of the backward encoder of a bidirectional model.
+func (m *Model) BackWord(input *Node, prevs []Nodes, dropout bool) (states []Nodes, out *Node, err error) {
+	return m.step(m.back, input, prevs, dropout)
+}
+
+// step runs one step of a stack of recurrent layers. If dropout is true, the output of each layer is dropped out,
+// as its spec says, before it is fed to the layer above. The states carried to the next step are never dropped out.
+func (m *Model) step(layers []cell, input *Node, prevs []Nodes, dropout bool) (states []Nodes, out *Node, err error) {
+	if prevs == nil {
+		prevs = m.prevs
+	}
+
+	states = make([]Nodes, len(layers))
+	out = input
+	for i, l := range layers {
+		if states[i], err = l.step(out, prevs[i]); err != nil {
 			return
 		}
+		out = states[i][0]
 
-		hiddens = append(hiddens, h1)
-		exps = append(exps, e)
-		if runningSum == nil {
-			runningSum = e
-		} else {
-			if runningSum, err = m.a.Sum(runningSum, e); err != nil {
+		if p := m.spec.Layers[i].Dropout; dropout && p > 0 {
+			if out, err = Dropout(out, p); err != nil {
 				return
 			}
 		}
-		prev0 = h0
-		prev1 = h1
 	}
+	return
+}
 
+// attend weighs the hidden states by their attention, and sums them into a context.
+// The attention weights of each hidden state are returned as well.
+func (m *Model) attend(a *Attn, hiddens, exps Nodes, runningSum *Node) (context *Node, weights Nodes, err error) {
 	// build context nodes
-	var context *Node
+	weights = make(Nodes, 0, len(hiddens))
 	for i, h := range hiddens {
 		var weight, ctx *Node
-		if weight, err = HadamardDiv(exps[i], runningSum); err != nil {
+		if weight, err = a.Weight(exps[i], runningSum); err != nil {
 			return
 		}
+		weights = append(weights, weight)
 
-		if ctx, err = HadamardProd(weight, h); err != nil {
+		if ctx, err = a.Apply(weight, h); err != nil {
 			ioutil.WriteFile("error.dot", []byte(h.RestrictedToDot(2, 9)), 0644)
 			return
 		}
@@ -155,86 +340,302 @@ func (m *Model) Fwd(s lingo.AnnotatedSentence) (prob *Node, err error) {
 			return
 		}
 	}
+	return
+}
+
+// classify turns a context into the probability of each target. The probabilities of a multi-label model
+// are independent of each other, so they need not sum to 1.
+// The context goes through the dense layers of the head first. If dropout is true, their outputs are dropped out as the spec says.
+func (m *Model) classify(context *Node, dropout bool) (prob *Node, err error) {
+	for i, l := range m.head {
+		if context, err = l.Activate(context); err != nil {
+			return
+		}
+		if p := m.spec.Head.Hidden[i].Dropout; dropout && p > 0 {
+			if context, err = Dropout(context, p); err != nil {
+				return
+			}
+		}
+	}
 
 	var finalLayer *Node
 	if finalLayer, err = Mul(m.p, context); err != nil {
 		return
 	}
+	if m.pb != nil {
+		if finalLayer, err = Add(finalLayer, m.pb); err != nil {
+			return
+		}
+	}
+	if m.spec.multiLabel() {
+		return Sigmoid(finalLayer)
+	}
 	return SoftMax(finalLayer)
 }
 
-func (m *Model) CostFn(s lingo.AnnotatedSentence, target Target) (cost *Node, err error) {
-	var prob *Node
-	if prob, err = m.Fwd(s); err != nil {
-		err = errors.Wrap(err, "FWD")
+// CostFn is the negative log likelihood of the target. The target of a single label model is a one-hot vector,
+// and its cost is the cross entropy. The target of a multi-label model has a 1 for each of its labels, and its cost
+// is the binary cross entropy of every label.
+//
+// The cost of each target is scaled by its class weight. The focal loss further scales it by (1-p)^γ,
+// where p is the probability given to the right answer, so that the examples the model already gets right count for less.
+func (m *Model) CostFn(prob, target *Node) (cost *Node, err error) {
+	var p, logProb, ll *Node
+	if p, err = m.squeeze(prob); err != nil {
+		return
+	}
+	if logProb, err = Log(p); err != nil {
 		return
 	}
-	logProb := Must(Neg(Must(Log(prob))))
-	return Slice(logProb, S(int(target)))
+	if ll, err = HadamardProd(target, logProb); err != nil {
+		return
+	}
+	if ll, err = m.focus(ll, p); err != nil {
+		return
+	}
+
+	if m.spec.multiLabel() {
+		var notTarget, notProb, logNotProb, neg *Node
+		if notTarget, err = Sub(m.ones, target); err != nil {
+			return
+		}
+		if notProb, err = Sub(m.ones, p); err != nil {
+			return
+		}
+		if logNotProb, err = Log(notProb); err != nil {
+			return
+		}
+		if neg, err = HadamardProd(notTarget, logNotProb); err != nil {
+			return
+		}
+		if neg, err = m.focus(neg, notProb); err != nil {
+			return
+		}
+		if ll, err = Add(ll, neg); err != nil {
+			return
+		}
+	}
+
+	if ll, err = HadamardProd(ll, m.cw); err != nil {
+		return
+	}
+	if cost, err = Sum(ll); err != nil {
+		return
+	}
+	return Neg(cost)
 }
 
-func (m *Model) Train(solver Solver, pair example) (c float64, err error) {
-	var g *ExprGraph
-	var cost *Node
-	if cost, err = m.CostFn(pair.dep.AnnotatedSentence, pair.target); err != nil {
+// squeeze maps probabilities from [0, 1] into [ε, 1-ε]. The cost takes the log of every probability, weighted by its target,
+// so a probability that rounds to 0 would otherwise make the cost and its gradient NaN even where the target is 0.
+// The multi-label cost also takes the log of 1-p, which a sigmoid that rounds to 1 would make infinite.
+func (m *Model) squeeze(prob *Node) (retVal *Node, err error) {
+	if retVal, err = Mul(prob, m.span); err != nil {
 		return
 	}
+	return Add(retVal, m.eps)
+}
 
-	g = m.g.SubgraphRoots(cost)
+// focus scales the log likelihood of each target by (1-p)^γ for the focal loss. p is the probability of the outcome the log likelihood is of.
+// p must be squeezed, since for γ < 1 the gradient of (1-p)^γ is infinite where p is 1.
+func (m *Model) focus(ll, p *Node) (retVal *Node, err error) {
+	if m.gm == nil {
+		return ll, nil
+	}
+	var miss, mod *Node
+	if miss, err = Sub(m.ones, p); err != nil {
+		return
+	}
+	if mod, err = Pow(miss, m.gm); err != nil {
+		return
+	}
+	return HadamardProd(ll, mod)
+}
 
-	// f, _ := os.OpenFile("LOOOOG", os.O_APPEND|os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
-	// logger := log.New(f, "", 0)
+// ClassWeights returns the weight of each target in the cost.
+func (m *Model) ClassWeights() []float64 {
+	data := m.cw.Value().Data().([]float)
+	retVal := make([]float64, len(data))
+	for i, w := range data {
+		retVal[i] = float64(w)
+	}
+	return retVal
+}
 
-	// machine := NewLispMachine(g, WithLogger(logger), WithWatchlist(), LogBothDir())
-	machine := NewLispMachine(g)
-	if err = machine.RunAll(); err != nil {
-		if ctxerr, ok := err.(contextualError); ok {
-			ioutil.WriteFile("error.dot", []byte(ctxerr.Node().RestrictedToDot(2, 9)), 0644)
+// SetClassWeights sets the weight of each target in the cost. A new model weighs every target equally.
+func (m *Model) SetClassWeights(weights []float64) error {
+	if len(weights) != m.cats {
+		return errors.Errorf("Expected %d class weights. Got %d", m.cats, len(weights))
+	}
+	backing := make([]float, len(weights))
+	for i, w := range weights {
+		if w < 0 {
+			return errors.Errorf("Class weight of %v is negative: %v", m.labels[i], w)
 		}
-		return
+		backing[i] = float(w)
 	}
+	return Let(m.cw, tensor.New(tensor.WithShape(m.cats), tensor.WithBacking(backing)))
+}
 
-	v := cost.Value()
-	switch v.Dtype() {
-	case Float32:
-		c = float64(v.Data().(float32))
-	case Float64:
-		c = v.Data().(float64)
+// Train trains on up to m.BatchSize() examples.
+// The gradients are averaged across the examples before the solver takes a step.
+func (m *Model) Train(solver *adaGradSolver, exs []example) (c float64, err error) {
+	if m.vm == nil {
+		return 0, errors.New("The model was built to predict only")
 	}
+	defer m.vm.Reset()
 
-	// machine.UnbindAll()
+	var rows map[int][]float
+	if c, rows, err = m.backprop(exs); err != nil {
+		return
+	}
 
-	err = solver.Step(m.Learnables())
+	if err = solver.Step(m.dense); err != nil {
+		return
+	}
+	err = solver.updateRows(m.emb, rows)
 	return
 }
 
-func (m *Model) PredPreparsed(dep *lingo.Dependency) (class Target, err error) {
-	var prob *Node
-	if prob, err = m.Fwd(dep.AnnotatedSentence); err != nil {
-		err = errors.Wrap(err, "Fwd failed")
+// backprop runs the training graph on up to m.BatchSize() examples. The gradients of the dense learnables
+// are left on the nodes, while the gradients of the embedding are returned by row.
+// The caller is responsible for resetting m.vm once the gradients have been used.
+func (m *Model) backprop(exs []example) (c float64, rows map[int][]float, err error) {
+	if m.vm == nil {
+		err = errors.New("The model was built to predict only")
 		return
 	}
-	g := m.g.SubgraphRoots(prob)
-	machine := NewLispMachine(g, ExecuteFwdOnly())
-	if err = machine.RunAll(); err != nil {
+	if len(exs) > len(m.slots) {
+		err = errors.Errorf("Batch of %d examples exceeds batch size %d", len(exs), len(m.slots))
 		return
 	}
 
-	val := prob.Value().(tensor.Tensor)
+	for i, s := range m.slots {
+		var view []lingo.AnnotatedSentence
+		var targets []Target
+		if i < len(exs) {
+			var views [][]lingo.AnnotatedSentence
+			if views, err = m.views(exs[i].doc); err != nil {
+				err = errors.Wrapf(err, "Unable to train on %v", exs[i].name)
+				return
+			}
+			if exs[i].view >= len(views) {
+				err = errors.Errorf("%v has %d views. Cannot train on view %d", exs[i].name, len(views), exs[i].view)
+				return
+			}
+			view = views[exs[i].view]
+			targets = exs[i].labels()
+		}
+		if err = s.bind(m, view, targets); err != nil {
+			return
+		}
+	}
+	if err = Let(m.scale, newScalar(1/float64(len(exs)))); err != nil {
+		return
+	}
 
-	var t tensor.Tensor
-	if t, err = tensor.Argmax(val, 0); err != nil {
+	if err = m.vm.RunAll(); err != nil {
+		if ctxerr, ok := err.(contextualError); ok {
+			ioutil.WriteFile("error.dot", []byte(ctxerr.Node().RestrictedToDot(2, 9)), 0644)
+		}
 		return
 	}
-	return Target(t.ScalarValue().(int)), nil
 
+	c = float64(m.cost.Value().Data().(float))
+
+	// accumulate the gradients of the words into the rows of the embedding
+	rows = make(map[int][]float)
+	if m.spec.FreezeEmbedding {
+		return
+	}
+	for _, s := range m.slots {
+		for i, id := range s.ids {
+			if id < 0 {
+				continue
+			}
+			var grad Value
+			if grad, err = s.words[i].Grad(); err != nil {
+				return
+			}
+			row, ok := rows[id]
+			if !ok {
+				row = make([]float, len(grad.Data().([]float)))
+				rows[id] = row
+			}
+			for j, v := range grad.Data().([]float) {
+				row[j] += v
+			}
+		}
+	}
+	return
+}
+
+func (m *Model) PredPreparsed(doc Document) (class Target, err error) {
+	var p Prediction
+	if p, err = m.PredictProba(doc); err != nil {
+		return
+	}
+	return p.Target, nil
+}
+
+// predict runs the prediction graph on each view of the document. It returns the probability of each target, and the attention
+// paid to each word of the document, both averaged across the views. Words the model did not read have no attention.
+func (m *Model) predict(doc Document) (probs []float64, attn []WordWeight, err error) {
+	var views [][]lingo.AnnotatedSentence
+	if views, err = m.views(doc); err != nil {
+		return
+	}
+
+	words := doc.Words()
+	idx := make(map[*lingo.Annotation]int, len(words))
+	attn = make([]WordWeight, len(words))
+	for i, a := range words {
+		idx[a] = i
+		attn[i].Annotation = a
+	}
+	probs = make([]float64, m.cats)
+
+	n := float64(len(views))
+	s, vm := m.eval, m.pvm
+	if m.training {
+		s, vm = m.slots[0], m.svm
+	}
+	for _, view := range views {
+		if err = s.bind(m, view, nil); err != nil {
+			return
+		}
+		if err = vm.RunAll(); err != nil {
+			vm.Reset()
+			return
+		}
+
+		for i, v := range s.prob.Value().Data().([]float) {
+			probs[i] += float64(v) / n
+		}
+		for r, weights := range s.wordWeights(m.q) {
+			for j, w := range weights {
+				attn[idx[s.rows[r][j]]].Weight += w / n
+			}
+		}
+		vm.Reset()
+	}
+	return
+}
+
+func argmax(a []float64) Target {
+	var max int
+	for i, v := range a {
+		if v > a[max] {
+			max = i
+		}
+	}
+	return Target(max)
 }
 
 func (m *Model) Pred(s string) (class Target, err error) {
-	var dep *lingo.Dependency
-	if dep, err = pipeline(s, strings.NewReader(s)); err != nil {
+	var doc Document
+	if doc, err = pipeline(s, strings.NewReader(s)); err != nil {
 		err = errors.Wrap(err, "Basic NLP pipeline failed")
 		return
 	}
-	return m.PredPreparsed(dep)
+	return m.PredPreparsed(doc)
 }
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	. "github.com/chewxy/gorgonia"
+	"github.com/chewxy/gorgonia/tensor"
+	"github.com/chewxy/lingo"
+	"github.com/chewxy/lingo/corpus"
+)
+
+var testLabels = []string{"Left", "Neutral", "Right"}
+
+var testVocab = []string{"-UNKNOWN-", "the", "senate", "passed", "a", "bill", "on", "tuesday", "critics", "said", "it", "fails", "voters"}
+
+// testSpec is a small architecture with dropout, so that tests run quickly.
+func testSpec() Spec {
+	spec := defaultSpec()
+	spec.Layers = []LayerSpec{{Hidden: 6, Dropout: 0.5}, {Hidden: 5}}
+	spec.MaxQuery = 8
+	return spec
+}
+
+// newTestModel creates a model over testVocab, with a random embedding.
+func newTestModel(t *testing.T, spec Spec, batch int) *Model {
+	t.Helper()
+	c := corpus.New()
+	for _, w := range testVocab {
+		c.Add(w)
+	}
+
+	const d = 4
+	m, err := NewModel(tensor.Shape{c.Size(), d}, Float, spec, testLabels, batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.c = c
+	m.SetEmbed(tensor.New(tensor.WithShape(c.Size(), d), tensor.WithBacking(GlorotU(1)(Float, c.Size(), d))))
+	return m
+}
+
+// testDoc builds a parsed document out of sentences of space separated words.
+func testDoc(sentences ...string) Document {
+	doc := make(Document, len(sentences))
+	for i, s := range sentences {
+		words := lingo.AnnotatedSentence{lingo.RootAnnotation()}
+		for _, w := range strings.Fields(s) {
+			a := lingo.NewAnnotation()
+			a.Value = w
+			words = append(words, a)
+		}
+		doc[i] = &lingo.Dependency{AnnotatedSentence: words}
+	}
+	return doc
+}
+
+func TestLearnables(t *testing.T) {
+	hierarchical := testSpec()
+	hierarchical.Cell = lstmCell
+	hierarchical.Bidirectional = true
+	hierarchical.Sentences = 2
+
+	head := testSpec()
+	head.Attention = scalarAttention
+	head.Head = HeadSpec{
+		Hidden: []DenseSpec{{Size: 4, Activation: reluAct, Dropout: 0.2}, {Size: 3, Activation: tanhAct}},
+		Bias:   true,
+		Output: sigmoidOutput,
+	}
+	head.Loss = LossSpec{Kind: focalLoss, Gamma: 2}
+
+	specs := []struct {
+		name string
+		spec Spec
+	}{
+		{"default", testSpec()},
+		{"hierarchical", hierarchical},
+		{"head", head},
+	}
+
+	for _, tc := range specs {
+		m := newTestModel(t, tc.spec, 2)
+		learnables := make(map[*Node]bool)
+		for _, n := range m.Learnables() {
+			if learnables[n] {
+				t.Errorf("%v: %v is learnable twice", tc.name, n.Name())
+			}
+			learnables[n] = true
+		}
+
+		// inputs with a value of their own got it from WithInit or SetEmbed. The class weights are set rather than learned
+		for _, n := range m.g.AllNodes() {
+			if n.Op() != nil || n.Value() == nil || n == m.cw {
+				continue
+			}
+			if !learnables[n] {
+				t.Errorf("%v: %v is initialised but not learnable", tc.name, n.Name())
+			}
+		}
+	}
+}
+
+func equalProbs(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestEvalModeIsDeterministic(t *testing.T) {
+	m := newTestModel(t, testSpec(), 2)
+	doc := testDoc("the senate passed a bill on tuesday", "critics said it fails voters")
+
+	first, err := m.PredictProba(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		p, err := m.PredictProba(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !equalProbs(p.Probs, first.Probs) {
+			t.Fatalf("Prediction %d is %v. Expected %v", i, p.Probs, first.Probs)
+		}
+	}
+
+	// predictions with dropout should vary, otherwise eval mode proves nothing
+	m.TrainMode()
+	var varied bool
+	for i := 0; i < 5 && !varied; i++ {
+		p, err := m.PredictProba(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		varied = !equalProbs(p.Probs, first.Probs)
+	}
+	if !varied {
+		t.Error("Predictions in train mode are the same as in eval mode. Expected dropout to change them")
+	}
+
+	m.EvalMode()
+	p, err := m.PredictProba(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalProbs(p.Probs, first.Probs) {
+		t.Errorf("Back in eval mode the prediction is %v. Expected %v", p.Probs, first.Probs)
+	}
+}
+
+// finite reports whether none of the values is NaN or infinite.
+func finite(values ...float) bool {
+	for _, v := range values {
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSaturatedCost(t *testing.T) {
+	softmax := testSpec()
+	softmax.Head.Bias = true
+
+	sigmoid := softmax
+	sigmoid.Head.Output = sigmoidOutput
+
+	focal := softmax
+	focal.Loss = LossSpec{Kind: focalLoss, Gamma: 0.5}
+
+	multiFocal := sigmoid
+	multiFocal.Loss = focal.Loss
+
+	// the bias saturates the output, so that the probability of some targets rounds to 0 or 1
+	cases := []struct {
+		name    string
+		spec    Spec
+		bias    []float
+		targets []Target
+	}{
+		{"softmax", softmax, []float{60, -60, 0}, []Target{1}},
+		{"sigmoid", sigmoid, []float{60, -60, 0}, []Target{0, 2}},
+		{"sigmoid without labels", sigmoid, []float{60, -60, 0}, []Target{}},
+		{"focal", focal, []float{60, -60, 0}, []Target{0}},
+		{"multi-label focal", multiFocal, []float{60, -60, 0}, []Target{0}},
+	}
+
+	doc := testDoc("the senate passed a bill on tuesday")
+	for _, tc := range cases {
+		m := newTestModel(t, tc.spec, 1)
+		if err := Let(m.pb, tensor.New(tensor.WithShape(len(tc.bias)), tensor.WithBacking(tc.bias))); err != nil {
+			t.Fatal(err)
+		}
+
+		ex := example{name: tc.name, doc: doc, targets: tc.targets}
+		if len(tc.targets) > 0 {
+			ex.target = tc.targets[0]
+		}
+		c, rows, err := m.backprop([]example{ex})
+		if err != nil {
+			t.Fatalf("%v: %v", tc.name, err)
+		}
+		if !finite(float(c)) {
+			t.Errorf("%v: cost is %v. Expected it to be finite", tc.name, c)
+		}
+		for _, n := range m.dense {
+			grad, err := n.Grad()
+			if err != nil {
+				t.Fatal(err)
+			}
+			values, ok := grad.Data().([]float)
+			if !ok {
+				values = []float{grad.Data().(float)}
+			}
+			if !finite(values...) {
+				t.Errorf("%v: gradient of %v is not finite", tc.name, n.Name())
+			}
+		}
+		for id, row := range rows {
+			if !finite(row...) {
+				t.Errorf("%v: gradient of word %d is not finite", tc.name, id)
+			}
+		}
+	}
+}
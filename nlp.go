@@ -9,6 +9,7 @@ import (
 	"github.com/chewxy/lingo/lexer"
 	"github.com/chewxy/lingo/pos"
 	"github.com/kljensen/snowball"
+	"github.com/pkg/errors"
 )
 
 var (
@@ -47,16 +48,18 @@ func loadModels() (err error) {
 	dl := defaultDepModelLoc
 	cl := defaultClusterLoc
 
-	if *posModelLoc != "" {
-		pl = *posModelLoc
+	if posModelLoc != "" {
+		pl = posModelLoc
 	}
-	if *depModelLoc != "" {
-		dl = *depModelLoc
+	if depModelLoc != "" {
+		dl = depModelLoc
 	}
-	if *clusterLoc != "" {
-		cl = *clusterLoc
+	if clusterLoc != "" {
+		cl = clusterLoc
 	}
 
+	modelLocs = []string{pl, dl, cl}
+
 	if posModel, err = pos.Load(pl); err != nil {
 		return
 	}
@@ -81,7 +84,8 @@ func loadModels() (err error) {
 	return nil
 }
 
-func pipeline(name string, f io.Reader) (*lingo.Dependency, error) {
+// pipeline parses every sentence of the text.
+func pipeline(name string, f io.Reader) (doc Document, err error) {
 	consOpts := []pos.ConsOpt{
 		pos.WithModel(posModel),
 		pos.WithStemmer(stemmer{}),
@@ -102,13 +106,29 @@ func pipeline(name string, f io.Reader) (*lingo.Dependency, error) {
 	go p.Run()
 	go d.Run()
 
-	select {
-	case err := <-l.Errors:
-		return nil, err
-	case err := <-d.Error:
-		return nil, err
-	case dep := <-d.Output:
-		return dep, nil
+	lexErrs, depErrs := l.Errors, d.Error
+	for {
+		select {
+		case err, ok := <-lexErrs:
+			if !ok {
+				lexErrs = nil
+				continue
+			}
+			return nil, err
+		case err, ok := <-depErrs:
+			if !ok {
+				depErrs = nil
+				continue
+			}
+			return nil, err
+		case dep, ok := <-d.Output:
+			if !ok {
+				if len(doc) == 0 {
+					return nil, errors.Errorf("No sentences in %v", name)
+				}
+				return doc, nil
+			}
+			doc = append(doc, dep)
+		}
 	}
-	panic("Unreachable")
 }
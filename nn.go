@@ -1,8 +1,6 @@
 package main
 
 import (
-	"bytes"
-	"encoding/gob"
 	"fmt"
 
 	. "github.com/chewxy/gorgonia"
@@ -10,50 +8,71 @@ import (
 	"github.com/pkg/errors"
 )
 
+// activation functions of dense layers
+const (
+	sigmoidAct = "sigmoid"
+	tanhAct    = "tanh"
+	reluAct    = "relu"
+	linearAct  = "linear"
+)
+
+var activations = map[string]func(*Node) (*Node, error){
+	sigmoidAct: Sigmoid,
+	tanhAct:    Tanh,
+	reluAct:    Rectify,
+	linearAct:  nil,
+}
+
+// FC is a fully connected layer: fn(wx + b). It holds no per-input state, so it can be applied to any number of inputs.
 type FC struct {
 	g *ExprGraph
-	w *Node
+	w *Node // (output, input) matrix
 	b *Node
 
-	Fn  func(*Node) (*Node, error)
-	Act *Node // output
+	Fn func(*Node) (*Node, error) // activation. nil is linear
 }
 
-func NewFC(g *ExprGraph, input tensor.Shape, t tensor.Dtype, winit, binit InitWFn) *FC {
-	if input.TotalSize() != 2 {
-		panic("Expects a matrix shape")
+// NewFC creates a fully connected layer that maps vectors of inputSize to vectors of outputSize.
+// act is one of the keys of activations.
+func NewFC(name string, g *ExprGraph, inputSize, outputSize int, t tensor.Dtype, act string) (*FC, error) {
+	if inputSize < 1 || outputSize < 1 {
+		return nil, errors.Errorf("Invalid shape (%d, %d) for %v", outputSize, inputSize, name)
+	}
+	fn, ok := activations[act]
+	if !ok {
+		return nil, errors.Errorf("Unknown activation %q for %v", act, name)
 	}
 
-	w := NewMatrix(g, t, WithShape(input...), WithInit(winit))
-	b := NewVector(g, t, WithShape(input[1]), WithInit(binit))
-	l := &FC{
+	return &FC{
 		g: g,
-		w: w,
-		b: b,
+		w: NewMatrix(g, t, WithShape(outputSize, inputSize), WithName(fmt.Sprintf("%v.w", name)), WithInit(GlorotU(1))),
+		b: NewVector(g, t, WithShape(outputSize), WithName(fmt.Sprintf("%v.b", name)), WithInit(Zeroes())),
 
-		Fn: Sigmoid,
-	}
-	return l
+		Fn: fn,
+	}, nil
 }
 
 func (l *FC) Activate(x *Node) (retVal *Node, err error) {
-	if l.Act != nil {
-		return l.Act, nil
+	if !x.IsVector() || x.Shape().TotalSize() != l.w.Shape()[1] {
+		return nil, errors.Errorf("%v expects a vector of %d. Got %v", l.w.Name(), l.w.Shape()[1], x.Shape())
 	}
 
-	var xw, xwb *Node
-	if xw, err = Mul(x, l.w); err != nil {
+	var wx, wxb *Node
+	if wx, err = Mul(l.w, x); err != nil {
 		return
 	}
-
-	if xwb, err = Add(xw, l.b); err != nil {
+	if wxb, err = Add(wx, l.b); err != nil {
 		return
 	}
 
-	l.Act, err = l.Fn(xwb)
-	return l.Act, err
+	if l.Fn == nil {
+		return wxb, nil
+	}
+	return l.Fn(wxb)
 }
 
+func (l *FC) Learnables() Nodes { return Nodes{l.w, l.b} }
+
 // Banana is a standard GRU node. Geddit?
 type Banana struct {
 	g *ExprGraph
@@ -149,9 +168,124 @@ func (l *Banana) Activate(x, prev *Node) (retVal *Node, err error) {
 	return
 }
 
+func (l *Banana) Learnables() Nodes {
+	return Nodes{
+		l.u, l.w, l.b,
+		l.uz, l.wz, l.bz,
+		l.ur, l.wr, l.br,
+	}
+}
+
+// cell is a recurrent layer. Its state is one or more nodes, the first of which is the output of the layer.
+type cell interface {
+	// step reads x, given the previous state, and returns the next state.
+	step(x *Node, prev Nodes) (Nodes, error)
+	Learnables() Nodes
+}
+
+// newCell creates a recurrent layer of the given type.
+func newCell(kind, name string, g *ExprGraph, inputSize, hiddenSize int, dt tensor.Dtype) cell {
+	if kind == lstmCell {
+		return NewLSTM(name, g, inputSize, hiddenSize, dt)
+	}
+	return NewGRU(name, g, inputSize, hiddenSize, dt)
+}
+
+func (l *Banana) step(x *Node, prev Nodes) (Nodes, error) {
+	h, err := l.Activate(x, prev[0])
+	return Nodes{h}, err
+}
+
+// LSTM is a standard long short-term memory layer. Its state is the hidden state and the cell state.
+type LSTM struct {
+	g *ExprGraph
+
+	// input gate
+	wi *Node
+	ui *Node
+	bi *Node
+
+	// forget gate
+	wf *Node
+	uf *Node
+	bf *Node
+
+	// output gate
+	wo *Node
+	uo *Node
+	bo *Node
+
+	// cell candidate
+	wc *Node
+	uc *Node
+	bc *Node
+}
+
+func NewLSTM(name string, g *ExprGraph, inputSize, hiddenSize int, dt tensor.Dtype) *LSTM {
+	w := func(gate string) *Node {
+		return NewMatrix(g, dt, WithShape(hiddenSize, inputSize), WithName(fmt.Sprintf("%v.w%v", name, gate)), WithInit(Gaussian(0, 0.08)))
+	}
+	u := func(gate string) *Node {
+		return NewMatrix(g, dt, WithShape(hiddenSize, hiddenSize), WithName(fmt.Sprintf("%v.u%v", name, gate)), WithInit(Gaussian(0, 0.08)))
+	}
+	b := func(gate string, init InitWFn) *Node {
+		return NewVector(g, dt, WithShape(hiddenSize), WithName(fmt.Sprintf("%v.b%v", name, gate)), WithInit(init))
+	}
+
+	return &LSTM{
+		g: g,
+
+		wi: w("i"), ui: u("i"), bi: b("i", Zeroes()),
+		// a forget bias of 1 keeps the cell state by default, which helps gradients flow early in training
+		wf: w("f"), uf: u("f"), bf: b("f", Ones()),
+		wo: w("o"), uo: u("o"), bo: b("o", Zeroes()),
+		wc: w("c"), uc: u("c"), bc: b("c", Zeroes()),
+	}
+}
+
+// gate computes fn(wx + uh + b)
+func gate(w, u, b, x, h *Node, fn func(*Node) (*Node, error)) *Node {
+	wx := Must(Mul(w, x))
+	uh := Must(Mul(u, h))
+	return Must(fn(
+		Must(Add(
+			Must(Add(wx, uh)),
+			b))))
+}
+
+func (l *LSTM) Activate(x, prevH, prevC *Node) (h, c *Node, err error) {
+	i := gate(l.wi, l.ui, l.bi, x, prevH, Sigmoid)
+	f := gate(l.wf, l.uf, l.bf, x, prevH, Sigmoid)
+	o := gate(l.wo, l.uo, l.bo, x, prevH, Sigmoid)
+	candidate := gate(l.wc, l.uc, l.bc, x, prevH, Tanh)
+
+	c = Must(Add(
+		Must(HadamardProd(f, prevC)),
+		Must(HadamardProd(i, candidate))))
+	h = Must(HadamardProd(o, Must(Tanh(c))))
+	return
+}
+
+func (l *LSTM) step(x *Node, prev Nodes) (Nodes, error) {
+	h, c, err := l.Activate(x, prev[0], prev[1])
+	return Nodes{h, c}, err
+}
+
+func (l *LSTM) Learnables() Nodes {
+	return Nodes{
+		l.wi, l.ui, l.bi,
+		l.wf, l.uf, l.bf,
+		l.wo, l.uo, l.bo,
+		l.wc, l.uc, l.bc,
+	}
+}
+
+// Attn is an attention layer. Vector attention weighs each dimension of a hidden state on its own.
+// Scalar attention weighs the whole hidden state.
 type Attn struct {
 	g    *ExprGraph
 	w    *Node
+	v    *Node // (c) vector that scores a hidden state. nil for vector attention
 	Fn   func(*Node) (*Node, error)
 	name string
 }
@@ -164,6 +298,21 @@ func NewAttn(name string, g *ExprGraph, shape tensor.Shape, t tensor.Dtype) *Att
 	}
 }
 
+// NewScalarAttn creates an attention layer for hidden states of the given size, that gives each hidden state a single weight.
+func NewScalarAttn(name string, g *ExprGraph, size int, t tensor.Dtype) *Attn {
+	l := NewAttn(name, g, tensor.Shape{size, size}, t)
+	l.v = NewVector(g, t, WithShape(size), WithInit(GlorotU(1)), WithName(fmt.Sprintf("%s.v", name)))
+	return l
+}
+
+// newAttn creates an attention layer of the given type, for hidden states of the given size.
+func newAttn(kind, name string, g *ExprGraph, size int, t tensor.Dtype) *Attn {
+	if kind == scalarAttention {
+		return NewScalarAttn(name, g, size, t)
+	}
+	return NewAttn(name, g, tensor.Shape{size, size}, t)
+}
+
 func (l *Attn) Exp(x *Node) (retVal *Node, err error) {
 	// var wx, do, e *Node
 	var wx, e *Node
@@ -179,22 +328,38 @@ func (l *Attn) Exp(x *Node) (retVal *Node, err error) {
 		// if e, err = l.Fn(do); err != nil {
 		return
 	}
+	if l.v != nil {
+		if e, err = Mul(l.v, e); err != nil {
+			err = errors.Wrap(err, "ve")
+			return
+		}
+	}
 	return Exp(e)
 }
 
+func (l *Attn) Learnables() Nodes {
+	if l.v != nil {
+		return Nodes{l.w, l.v}
+	}
+	return Nodes{l.w}
+}
+
 func (l *Attn) Sum(a, b *Node) (retVal *Node, err error) {
 	return Add(a, b)
 }
 
+// Weight normalizes an exponentiated score by the sum of the scores.
 func (l *Attn) Weight(a, sum *Node) (retVal *Node, err error) {
-	return Div(a, sum)
+	if l.v != nil {
+		return Div(a, sum)
+	}
+	return HadamardDiv(a, sum)
 }
 
-func (l *Attn) GobEncode() (p []byte, err error) {
-	var buf bytes.Buffer
-	encoder := gob.NewEncoder(&buf)
-	if err = encoder.Encode(l.w.Value()); err != nil {
-		return
+// Apply weighs a hidden state.
+func (l *Attn) Apply(weight, h *Node) (retVal *Node, err error) {
+	if l.v != nil {
+		return Mul(h, weight)
 	}
-	return buf.Bytes(), nil
+	return HadamardProd(weight, h)
 }
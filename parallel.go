@@ -0,0 +1,115 @@
+package main
+
+import (
+	"sync"
+
+	. "github.com/chewxy/gorgonia"
+	"github.com/pkg/errors"
+)
+
+// trainer trains a model on up to BatchSize() examples per solver step.
+type trainer interface {
+	Train(solver *adaGradSolver, exs []example) (float64, error)
+	BatchSize() int
+}
+
+// parallelTrainer trains replicas of a model concurrently, each on its own batch of examples.
+// The gradients of the replicas are averaged into a single solver step on the model.
+type parallelTrainer struct {
+	m        *Model
+	replicas []*Model // replicas[0] is m
+}
+
+func newParallelTrainer(m *Model, workers int) (t *parallelTrainer, err error) {
+	t = &parallelTrainer{
+		m:        m,
+		replicas: []*Model{m},
+	}
+	for i := 1; i < workers; i++ {
+		var r *Model
+		if r, err = m.replicate(); err != nil {
+			return nil, errors.Wrapf(err, "Unable to create worker %d", i)
+		}
+		t.replicas = append(t.replicas, r)
+	}
+	return t, nil
+}
+
+func (t *parallelTrainer) BatchSize() int { return t.m.BatchSize() * len(t.replicas) }
+
+func (t *parallelTrainer) Train(solver *adaGradSolver, exs []example) (c float64, err error) {
+	if len(exs) > t.BatchSize() {
+		return 0, errors.Errorf("Batch of %d examples exceeds batch size %d", len(exs), t.BatchSize())
+	}
+
+	size := t.m.BatchSize()
+	var shards [][]example
+	for start := 0; start < len(exs); start += size {
+		end := start + size
+		if end > len(exs) {
+			end = len(exs)
+		}
+		shards = append(shards, exs[start:end])
+	}
+
+	costs := make([]float64, len(shards))
+	rows := make([]map[int][]float, len(shards))
+	errs := make([]error, len(shards))
+	var wg sync.WaitGroup
+	for i, shard := range shards {
+		wg.Add(1)
+		go func(i int, r *Model, shard []example) {
+			defer wg.Done()
+			costs[i], rows[i], errs[i] = r.backprop(shard)
+		}(i, t.replicas[i], shard)
+	}
+	wg.Wait()
+
+	for i := range shards {
+		defer t.replicas[i].vm.Reset()
+	}
+	for i, e := range errs {
+		if e != nil {
+			return 0, errors.Wrapf(e, "Worker %d failed", i)
+		}
+	}
+
+	// each replica's gradients are the mean over its shard,
+	// so weigh them by the size of the shard to get the mean over the whole batch
+	weights := make([]float, len(shards))
+	for i, shard := range shards {
+		weights[i] = float(float64(len(shard)) / float64(len(exs)))
+		c += float64(weights[i]) * costs[i]
+	}
+
+	for j, n := range t.m.dense {
+		w := n.Value().Data().([]float)
+		avg := make([]float, len(w))
+		for i := range shards {
+			var grad Value
+			if grad, err = t.replicas[i].dense[j].Grad(); err != nil {
+				return 0, errors.Wrapf(err, "No gradient for %v in worker %d", n.Name(), i)
+			}
+			for k, v := range grad.Data().([]float) {
+				avg[k] += weights[i] * v
+			}
+		}
+		solver.update(n.Name(), w, avg)
+	}
+
+	merged := make(map[int][]float)
+	for i, rs := range rows {
+		for id, g := range rs {
+			row, ok := merged[id]
+			if !ok {
+				row = make([]float, len(g))
+				merged[id] = row
+			}
+			for k, v := range g {
+				row[k] += weights[i] * v
+			}
+		}
+	}
+	err = solver.updateRows(t.m.emb, merged)
+	return
+}
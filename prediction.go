@@ -0,0 +1,151 @@
+package main
+
+import (
+	"math"
+	"strings"
+
+	"github.com/chewxy/lingo"
+	"github.com/pkg/errors"
+)
+
+// Prediction is the result of classifying a document.
+type Prediction struct {
+	Target     Target
+	Label      string
+	Probs      []float64 // probability of each label, indexed by Target
+	Confidence float64   // probability of Target
+	Margin     float64   // Confidence less the probability of the runner-up
+	Labels     []string  // every label with a probability of at least 0.5. Multi-label models only
+
+	Attention []WordWeight // attention paid to each word of the document
+
+	Uncertainty *Uncertainty // nil unless the prediction was sampled with dropout. See (*Model).PredictMC
+}
+
+// Uncertainty describes how much the samples of a Monte Carlo dropout prediction disagree.
+type Uncertainty struct {
+	Samples  int
+	Entropy  float64   // entropy of the mean probabilities, in nats. Higher is less certain
+	Variance []float64 // variance of the probability of each label across the samples, indexed by Target
+}
+
+// WordWeight is the attention paid to a word. Words that do not fit in the model are not read, so they have no weight.
+type WordWeight struct {
+	*lingo.Annotation
+	Weight float64
+}
+
+// newPrediction sums up the probabilities and attention the model computed for a document.
+func (m *Model) newPrediction(probs []float64, attn []WordWeight) Prediction {
+	class := argmax(probs)
+	var second float64
+	for i, p := range probs {
+		if Target(i) != class && p > second {
+			second = p
+		}
+	}
+
+	p := Prediction{
+		Target:     class,
+		Label:      m.labels[class],
+		Probs:      probs,
+		Confidence: probs[class],
+		Margin:     probs[class] - second,
+		Attention:  attn,
+	}
+	if m.spec.multiLabel() {
+		for i, prob := range probs {
+			if prob >= 0.5 {
+				p.Labels = append(p.Labels, m.labels[i])
+			}
+		}
+	}
+	return p
+}
+
+// PredictProba classifies a parsed document, keeping the probability of every label and the attention paid to every word.
+func (m *Model) PredictProba(doc Document) (p Prediction, err error) {
+	var probs []float64
+	var attn []WordWeight
+	if probs, attn, err = m.predict(doc); err != nil {
+		return
+	}
+	return m.newPrediction(probs, attn), nil
+}
+
+// PredictMC classifies a parsed document with Monte Carlo dropout: the document is run through the network
+// with dropout samples times, and the probabilities and attention are averaged across the runs.
+// How much the runs disagree is reported in the prediction's Uncertainty.
+func (m *Model) PredictMC(doc Document, samples int) (p Prediction, err error) {
+	if samples < 2 {
+		return p, errors.Errorf("Monte Carlo dropout needs at least 2 samples. Got %d", samples)
+	}
+
+	training := m.training
+	m.TrainMode()
+	defer func() { m.training = training }()
+
+	mean := make([]float64, m.cats)
+	sq := make([]float64, m.cats)
+	var attn []WordWeight
+	n := float64(samples)
+	for i := 0; i < samples; i++ {
+		var probs []float64
+		var a []WordWeight
+		if probs, a, err = m.predict(doc); err != nil {
+			return
+		}
+		for j, v := range probs {
+			mean[j] += v / n
+			sq[j] += v * v / n
+		}
+		if attn == nil {
+			attn = a
+			for j := range attn {
+				attn[j].Weight /= n
+			}
+			continue
+		}
+		for j := range a {
+			attn[j].Weight += a[j].Weight / n
+		}
+	}
+
+	u := &Uncertainty{Samples: samples, Variance: make([]float64, m.cats)}
+	for j, v := range mean {
+		if v > 0 {
+			u.Entropy -= v * math.Log(v)
+		}
+		u.Variance[j] = math.Max(sq[j]-v*v, 0)
+	}
+
+	p = m.newPrediction(mean, attn)
+	p.Uncertainty = u
+	return p, nil
+}
+
+// validSamples checks a number of Monte Carlo dropout samples. 0 predicts once, without dropout.
+func validSamples(samples int) error {
+	if samples < 0 || samples == 1 {
+		return errors.Errorf("Expected 0 samples, or at least 2 for Monte Carlo dropout. Got %d", samples)
+	}
+	return nil
+}
+
+// classifyDoc classifies a parsed document, with Monte Carlo dropout unless samples is 0.
+func classifyDoc(m *Model, doc Document, samples int) (Prediction, error) {
+	if samples != 0 {
+		return m.PredictMC(doc, samples)
+	}
+	return m.PredictProba(doc)
+}
+
+// predictText parses the text before classifying it. See classifyDoc.
+func predictText(m *Model, s string, samples int) (p Prediction, err error) {
+	var doc Document
+	if doc, err = pipeline(s, strings.NewReader(s)); err != nil {
+		err = errors.Wrap(err, "Basic NLP pipeline failed")
+		return
+	}
+	return classifyDoc(m, doc, samples)
+}
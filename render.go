@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+)
+
+// heat is a white to red ramp of the 256 colour terminal palette.
+var heat = []int{231, 224, 217, 210, 203, 196}
+
+// relativeWeights scales the attention weights so that the word with the most attention has a weight of 1.
+func relativeWeights(attn []WordWeight) []float64 {
+	var max float64
+	for _, w := range attn {
+		if w.Weight > max {
+			max = w.Weight
+		}
+	}
+
+	retVal := make([]float64, len(attn))
+	if max == 0 {
+		return retVal
+	}
+	for i, w := range attn {
+		retVal[i] = w.Weight / max
+	}
+	return retVal
+}
+
+// renderTerminal writes the sentence with each word highlighted by the attention paid to it. Redder words drove the prediction more.
+func renderTerminal(w io.Writer, p Prediction) (err error) {
+	if _, err = fmt.Fprintf(w, "%s (%.4f): ", p.Label, p.Confidence); err != nil {
+		return
+	}
+	for i, rel := range relativeWeights(p.Attention) {
+		colour := heat[int(rel*float64(len(heat)-1)+0.5)]
+		if _, err = fmt.Fprintf(w, "\x1b[30;48;5;%dm%s\x1b[0m ", colour, p.Attention[i].Value); err != nil {
+			return
+		}
+	}
+	_, err = fmt.Fprintln(w)
+	return
+}
+
+var htmlTmpl = template.Must(template.New("attention").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Attention</title>
+<style>
+body { font-family: sans-serif; }
+.prediction { margin-bottom: 1.5em; }
+.word { padding: 0.1em 0.2em; border-radius: 0.2em; }
+</style>
+</head>
+<body>
+{{range .}}<div class="prediction">
+<h3>{{.Name}}: {{.Label}} ({{printf "%.4f" .Confidence}})</h3>
+<p>{{range .Words}}<span class="word" title="{{printf "%.4f" .Weight}}" style="background-color: rgba(255, 0, 0, {{printf "%.3f" .Alpha}})">{{.Text}}</span> {{end}}</p>
+</div>
+{{end}}</body>
+</html>
+`))
+
+type htmlWord struct {
+	Text   string
+	Weight float64
+	Alpha  float64
+}
+
+type htmlPrediction struct {
+	Name       string
+	Label      string
+	Confidence float64
+	Words      []htmlWord
+}
+
+// renderHTML writes a page with each named prediction's sentence, highlighted by the attention paid to each word.
+func renderHTML(w io.Writer, names []string, preds []Prediction) error {
+	data := make([]htmlPrediction, len(preds))
+	for i, p := range preds {
+		data[i] = htmlPrediction{
+			Name:       names[i],
+			Label:      p.Label,
+			Confidence: p.Confidence,
+			Words:      make([]htmlWord, len(p.Attention)),
+		}
+		for j, rel := range relativeWeights(p.Attention) {
+			data[i].Words[j] = htmlWord{p.Attention[j].Value, p.Attention[j].Weight, rel}
+		}
+	}
+	return htmlTmpl.Execute(w, data)
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	maxRequestSize = 1 << 20
+	maxSamples     = 100 // most Monte Carlo dropout samples per text
+)
+
+type predictRequest struct {
+	Text    string `json:"text"`
+	Samples int    `json:"samples"` // Monte Carlo dropout samples. 0 predicts once, without dropout
+}
+
+type batchRequest struct {
+	Texts   []string `json:"texts"`
+	Samples int      `json:"samples"`
+}
+
+type wordWeight struct {
+	Word   string  `json:"word"`
+	Weight float64 `json:"weight"`
+}
+
+type predictResponse struct {
+	Label       string             `json:"label"`
+	Confidence  float64            `json:"confidence"`
+	Margin      float64            `json:"margin"`
+	Labels      []string           `json:"labels,omitempty"`
+	Probs       map[string]float64 `json:"probs"`
+	Attention   []wordWeight       `json:"attention"`
+	Uncertainty *uncertainty       `json:"uncertainty,omitempty"`
+	Error       string             `json:"error,omitempty"`
+}
+
+type uncertainty struct {
+	Samples  int                `json:"samples"`
+	Entropy  float64            `json:"entropy"`
+	Variance map[string]float64 `json:"variance"`
+}
+
+type batchResponse struct {
+	Predictions []predictResponse `json:"predictions"`
+}
+
+type metadata struct {
+	Labels     []string `json:"labels"`
+	Spec       Spec     `json:"spec"`
+	EmbShape   []int    `json:"embShape"`
+	Dtype      string   `json:"dtype"`
+	Vocabulary int      `json:"vocabulary"`
+	Workers    int      `json:"workers"`
+}
+
+// server answers prediction requests. A *Model is not safe for concurrent use, as its
+// graph holds the values of the sentence being predicted, so each request borrows a replica from the pool.
+type server struct {
+	pool     chan *Model
+	meta     metadata
+	maxBatch int
+}
+
+func newServer(m *Model, workers, maxBatch int) (s *server, err error) {
+	if workers < 1 {
+		workers = 1
+	}
+	s = &server{
+		pool:     make(chan *Model, workers),
+		maxBatch: maxBatch,
+		meta: metadata{
+			Labels:     m.Labels(),
+			Spec:       m.spec,
+			EmbShape:   []int(m.emb.Shape()),
+			Dtype:      m.t.String(),
+			Vocabulary: m.c.Size(),
+			Workers:    workers,
+		},
+	}
+
+	s.pool <- m
+	for i := 1; i < workers; i++ {
+		var r *Model
+		if r, err = m.replicate(); err != nil {
+			return nil, errors.Wrapf(err, "Unable to create replica %d", i)
+		}
+		s.pool <- r
+	}
+	return s, nil
+}
+
+func (s *server) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/predict", s.handlePredict)
+	mux.HandleFunc("/predict/batch", s.handleBatch)
+	mux.HandleFunc("/healthz", s.handleHealth)
+	mux.HandleFunc("/metadata", s.handleMetadata)
+	return mux
+}
+
+// predict borrows the first free model in the pool, then parses the text and runs it through the model.
+// The model is borrowed before parsing, which costs more than the model itself, so that the pool bounds
+// the work in flight across every request, and not just the model step.
+func (s *server) predict(text string, samples int) (pred Prediction, err error) {
+	m := <-s.pool
+	defer func() { s.pool <- m }()
+
+	var doc Document
+	if doc, err = pipeline(text, strings.NewReader(text)); err != nil {
+		err = errors.Wrap(err, "Basic NLP pipeline failed")
+		return
+	}
+	return classifyDoc(m, doc, samples)
+}
+
+func (s *server) response(pred Prediction) (resp predictResponse) {
+	resp.Label = pred.Label
+	resp.Confidence = pred.Confidence
+	resp.Margin = pred.Margin
+	resp.Labels = pred.Labels
+	resp.Probs = make(map[string]float64, len(pred.Probs))
+	for i, p := range pred.Probs {
+		resp.Probs[s.meta.Labels[i]] = p
+	}
+	resp.Attention = make([]wordWeight, len(pred.Attention))
+	for i, w := range pred.Attention {
+		resp.Attention[i] = wordWeight{w.Value, w.Weight}
+	}
+	if u := pred.Uncertainty; u != nil {
+		resp.Uncertainty = &uncertainty{
+			Samples:  u.Samples,
+			Entropy:  u.Entropy,
+			Variance: make(map[string]float64, len(u.Variance)),
+		}
+		for i, v := range u.Variance {
+			resp.Uncertainty.Variance[s.meta.Labels[i]] = v
+		}
+	}
+	return resp
+}
+
+func (s *server) handlePredict(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, http.StatusMethodNotAllowed, errors.New("Use POST"))
+		return
+	}
+
+	var req predictRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, errors.Wrap(err, "Unable to decode request"))
+		return
+	}
+	if strings.TrimSpace(req.Text) == "" {
+		httpError(w, http.StatusBadRequest, errors.New("No text given"))
+		return
+	}
+	if err := checkSamples(req.Samples); err != nil {
+		httpError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	pred, err := s.predict(req.Text, req.Samples)
+	if err != nil {
+		httpError(w, errorStatus(err), err)
+		return
+	}
+	if r.URL.Query().Get("format") == "html" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err = renderHTML(w, []string{"request"}, []Prediction{pred}); err != nil {
+			log.Printf("Unable to write response: %v", err)
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, s.response(pred))
+}
+
+// handleBatch predicts each text concurrently. A text that fails does not fail the batch; its error is reported in place.
+func (s *server) handleBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, http.StatusMethodNotAllowed, errors.New("Use POST"))
+		return
+	}
+
+	var req batchRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, errors.Wrap(err, "Unable to decode request"))
+		return
+	}
+	if s.maxBatch > 0 && len(req.Texts) > s.maxBatch {
+		httpError(w, http.StatusRequestEntityTooLarge, errors.Errorf("Batch of %d texts is larger than the maximum of %d", len(req.Texts), s.maxBatch))
+		return
+	}
+	if err := checkSamples(req.Samples); err != nil {
+		httpError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	resp := batchResponse{Predictions: make([]predictResponse, len(req.Texts))}
+	var wg sync.WaitGroup
+	for i, text := range req.Texts {
+		wg.Add(1)
+		go func(i int, text string) {
+			defer wg.Done()
+			pred, err := s.predict(text, req.Samples)
+			if err != nil {
+				resp.Predictions[i].Error = err.Error()
+				return
+			}
+			resp.Predictions[i] = s.response(pred)
+		}(i, text)
+	}
+	wg.Wait()
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (s *server) handleMetadata(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.meta)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Unable to write response: %v", err)
+	}
+}
+
+// checkSamples bounds the Monte Carlo dropout samples a request may ask for, as each sample is a full forward pass.
+func checkSamples(samples int) error {
+	if samples > maxSamples {
+		return errors.Errorf("At most %d samples are allowed. Got %d", maxSamples, samples)
+	}
+	return validSamples(samples)
+}
+
+// errorStatus is the status of a failed prediction. Documents rejected by the over-length policy are the client's fault.
+func errorStatus(err error) int {
+	if _, ok := errors.Cause(err).(OverLengthError); ok {
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+func httpError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// runServe loads a saved model and serves predictions over HTTP.
+func runServe(args []string) (err error) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	modelLoc := fs.String("model", "", "Location of the saved model")
+	addr := fs.String("addr", ":8080", "Address to listen on")
+	workers := fs.Int("workers", runtime.NumCPU(), "Number of texts to parse and predict concurrently. Each holds its own copy of the model")
+	maxBatch := fs.Int("maxBatch", 256, "Maximum number of texts in a batch request. 0 means no limit")
+	nlpFlags(fs)
+	fs.Parse(args)
+
+	var m *Model
+	if m, err = loadSavedModel(*modelLoc); err != nil {
+		return
+	}
+
+	var s *server
+	if s, err = newServer(m, *workers, *maxBatch); err != nil {
+		return
+	}
+	log.Printf("Serving %v on %v with %d workers", *modelLoc, *addr, len(s.pool))
+	return http.ListenAndServe(*addr, s.routes())
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+
+	. "github.com/chewxy/gorgonia"
+	"github.com/chewxy/gorgonia/tensor"
+	"github.com/chewxy/lingo"
+)
+
+// slot is the model unrolled over a document, for a single example.
+//
+// A sequential model reads the document as one row of q words. A hierarchical model reads up to m.sents rows,
+// one per sentence, each of q words, and then attends over the rows.
+// Rows shorter than q are padded, and documents with fewer sentences are padded with empty rows.
+// The padding is masked out of the attention, so it contributes nothing to the context.
+// Documents that do not fit are laid out by the over-length policy. See (*Model).views.
+type slot struct {
+	words    Nodes // embedding of each word. Word j of row i is at i*q + j
+	masks    Nodes // 1 for a word, 0 for padding
+	smasks   Nodes // 1 for a sentence, 0 for padding. Hierarchical models only
+	target   *Node // 1 for each label of the example, 0 otherwise. All zeroes for an unused slot
+	prob     *Node
+	cost     *Node
+	weights  Nodes // attention paid to each word, within its row
+	sweights Nodes // attention paid to each sentence. Hierarchical models only
+
+	ids  []int                     // IDs of the words bound to the slot. -1 for padding
+	rows []lingo.AnnotatedSentence // words bound to the slot
+
+	dropout bool // whether the encoder's outputs are dropped out
+}
+
+// newSlot unrolls the network over a document. Every slot shares the model's learnables.
+// A slot built without dropout is deterministic, and is used to predict in eval mode.
+func (m *Model) newSlot(name string, dropout bool) (s *slot, err error) {
+	n := m.q
+	if m.sents > 0 {
+		n = m.sents * m.q
+	}
+	s = &slot{
+		words:  make(Nodes, n),
+		masks:  make(Nodes, n),
+		target: NewVector(m.g, m.t, WithShape(m.cats), WithName(fmt.Sprintf("%s.target", name))),
+		ids:    make([]int, n),
+
+		dropout: dropout,
+	}
+
+	var context *Node
+	if m.sents == 0 {
+		if context, s.weights, err = m.encodeRow(s, name, 0); err != nil {
+			return
+		}
+	} else {
+		s.smasks = make(Nodes, m.sents)
+		contexts := make(Nodes, 0, m.sents)
+		exps := make(Nodes, 0, m.sents)
+		var runningSum *Node
+		for i := 0; i < m.sents; i++ {
+			var ctx, e *Node
+			var weights Nodes
+			if ctx, weights, err = m.encodeRow(s, name, i); err != nil {
+				return
+			}
+			s.weights = append(s.weights, weights...)
+
+			s.smasks[i] = NewScalar(m.g, m.t, WithName(fmt.Sprintf("%s.smask%d", name, i)))
+			if e, err = m.sa.Exp(ctx); err != nil {
+				return
+			}
+			if e, err = Mul(e, s.smasks[i]); err != nil {
+				return
+			}
+
+			contexts = append(contexts, ctx)
+			exps = append(exps, e)
+			if runningSum == nil {
+				runningSum = e
+			} else {
+				if runningSum, err = m.sa.Sum(runningSum, e); err != nil {
+					return
+				}
+			}
+		}
+		if context, s.sweights, err = m.attend(m.sa, contexts, exps, runningSum); err != nil {
+			return
+		}
+	}
+
+	if s.prob, err = m.classify(context, s.dropout); err != nil {
+		return
+	}
+	s.cost, err = m.CostFn(s.prob, s.target)
+	return
+}
+
+// encodeRow unrolls the encoder over the q words of a row, and attends over them.
+func (m *Model) encodeRow(s *slot, name string, row int) (context *Node, weights Nodes, err error) {
+	d := m.emb.Shape()[1]
+	hiddens := make(Nodes, 0, m.q)
+	exps := make(Nodes, 0, m.q)
+	var runningSum *Node
+
+	prevs := m.prevs
+	for j := 0; j < m.q; j++ {
+		i := row*m.q + j
+		s.words[i] = NewVector(m.g, m.t, WithShape(d), WithName(fmt.Sprintf("%s.word%d", name, i)))
+		s.masks[i] = NewScalar(m.g, m.t, WithName(fmt.Sprintf("%s.mask%d", name, i)))
+
+		var out *Node
+		if prevs, out, err = m.OneWord(s.words[i], prevs, s.dropout); err != nil {
+			return
+		}
+		hiddens = append(hiddens, out)
+	}
+
+	if m.back != nil {
+		back := make(Nodes, m.q)
+		prevs = m.prevs
+		for j := m.q - 1; j >= 0; j-- {
+			i := row*m.q + j
+			var states []Nodes
+			if states, back[j], err = m.BackWord(s.words[i], prevs, s.dropout); err != nil {
+				return
+			}
+			// the padding is at the end of a row, so it is read first. It must not change the state.
+			carried := make([]Nodes, len(states))
+			for k, state := range states {
+				carried[k] = make(Nodes, len(state))
+				for l, n := range state {
+					if carried[k][l], err = carry(n, prevs[k][l], s.masks[i]); err != nil {
+						return
+					}
+				}
+			}
+			prevs = carried
+		}
+
+		for j := range hiddens {
+			if hiddens[j], err = Concat(0, hiddens[j], back[j]); err != nil {
+				return
+			}
+		}
+	}
+
+	for j, h := range hiddens {
+		var e *Node
+		if e, err = m.a.Exp(h); err != nil {
+			return
+		}
+		if e, err = Mul(e, s.masks[row*m.q+j]); err != nil {
+			return
+		}
+
+		exps = append(exps, e)
+		if runningSum == nil {
+			runningSum = e
+		} else {
+			if runningSum, err = m.a.Sum(runningSum, e); err != nil {
+				return
+			}
+		}
+	}
+	return m.attend(m.a, hiddens, exps, runningSum)
+}
+
+// carry is h where the mask is 1, and prev where the mask is 0.
+func carry(h, prev, mask *Node) (retVal *Node, err error) {
+	var diff *Node
+	if diff, err = Sub(h, prev); err != nil {
+		return
+	}
+	if diff, err = Mul(diff, mask); err != nil {
+		return
+	}
+	return Add(prev, diff)
+}
+
+// bind sets the inputs of the slot to a view of a document, as laid out by m.views, and the labels of the example.
+// Nil rows mark the slot as unused.
+func (s *slot) bind(m *Model, rows []lingo.AnnotatedSentence, targets []Target) (err error) {
+	emb := m.emb.Value().Data().([]float)
+	d := m.emb.Shape()[1]
+	s.rows = rows
+
+	for i := range s.words {
+		r, j := i/m.q, i%m.q
+		var words lingo.AnnotatedSentence
+		if r < len(rows) {
+			words = rows[r]
+		}
+
+		var word, mask Value
+		switch {
+		case j < len(words):
+			id := m.WordID(words[j])
+			word = tensor.New(tensor.WithShape(d), tensor.WithBacking(emb[id*d:(id+1)*d]))
+			mask = newScalar(1)
+			s.ids[i] = id
+		case j == 0:
+			// an unused row or an empty sentence still needs one unmasked word,
+			// otherwise the attention weights are 0/0.
+			word = m.zero
+			mask = newScalar(1)
+			s.ids[i] = -1
+		default:
+			word = m.zero
+			mask = newScalar(0)
+			s.ids[i] = -1
+		}
+
+		if err = Let(s.words[i], word); err != nil {
+			return
+		}
+		if err = Let(s.masks[i], mask); err != nil {
+			return
+		}
+	}
+
+	for i, smask := range s.smasks {
+		// likewise, the first sentence is never masked
+		v := newScalar(0)
+		if i < len(rows) || i == 0 {
+			v = newScalar(1)
+		}
+		if err = Let(smask, v); err != nil {
+			return
+		}
+	}
+
+	hot := make([]float, m.cats)
+	if rows != nil {
+		for _, t := range targets {
+			hot[int(t)] = 1
+		}
+	}
+	return Let(s.target, tensor.New(tensor.WithShape(m.cats), tensor.WithBacking(hot)))
+}
+
+// floats returns the data of a vector or a scalar value.
+func floats(v Value) []float {
+	switch data := v.Data().(type) {
+	case []float:
+		return data
+	case float:
+		return []float{data}
+	}
+	return nil
+}
+
+// wordWeights returns the attention paid to each word bound to the slot, by row. In a hierarchical model
+// the attention paid to a word is scaled by the attention paid to its sentence, so that the weights of all the words sum to 1.
+// The weights are averaged across the dimensions of the hidden state.
+func (s *slot) wordWeights(q int) [][]float64 {
+	retVal := make([][]float64, len(s.rows))
+	for r, row := range s.rows {
+		var sw []float
+		if s.sweights != nil {
+			sw = floats(s.sweights[r].Value())
+		}
+
+		retVal[r] = make([]float64, len(row))
+		for j := range row {
+			w := floats(s.weights[r*q+j].Value())
+			var sum float64
+			for k, v := range w {
+				if sw != nil {
+					v *= sw[k]
+				}
+				sum += float64(v)
+			}
+			retVal[r][j] = sum / float64(len(w))
+		}
+	}
+	return retVal
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"math"
+
+	. "github.com/chewxy/gorgonia"
+	"github.com/pkg/errors"
+)
+
+// adaGradSolver is a plain AdaGrad solver. Unlike gorgonia's, its gradient history
+// is accessible, so it can be written into a training checkpoint.
+type adaGradSolver struct {
+	learnRate float64
+	eps       float64
+	clip      float64
+	l2reg     float64
+
+	cache map[string][]float // sum of squared gradients, keyed by node name
+}
+
+func newAdaGradSolver(learnRate, clip, l2reg float64) *adaGradSolver {
+	return &adaGradSolver{
+		learnRate: learnRate,
+		eps:       1e-8,
+		clip:      clip,
+		l2reg:     l2reg,
+
+		cache: make(map[string][]float),
+	}
+}
+
+func (s *adaGradSolver) Step(model Nodes) (err error) {
+	for _, n := range model {
+		var grad Value
+		if grad, err = n.Grad(); err != nil {
+			return errors.Wrapf(err, "No gradient for %v", n.Name())
+		}
+
+		w, ok := n.Value().Data().([]float)
+		if !ok {
+			return errors.Errorf("Expected %v to be of %v", n.Name(), Float)
+		}
+		g, ok := grad.Data().([]float)
+		if !ok {
+			return errors.Errorf("Expected gradient of %v to be of %v", n.Name(), Float)
+		}
+		s.update(n.Name(), w, g)
+	}
+	return nil
+}
+
+// update applies the gradient g to the weights w in place.
+func (s *adaGradSolver) update(name string, w, g []float) {
+	s.apply(w, g, s.cacheOf(name, len(w)))
+}
+
+// updateRows applies sparse gradients, keyed by row, to a matrix such as the word embeddings.
+func (s *adaGradSolver) updateRows(n *Node, rows map[int][]float) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	w, ok := n.Value().Data().([]float)
+	if !ok {
+		return errors.Errorf("Expected %v to be of %v", n.Name(), Float)
+	}
+
+	cols := n.Shape()[1]
+	cache := s.cacheOf(n.Name(), len(w))
+	for row, g := range rows {
+		start, end := row*cols, (row+1)*cols
+		s.apply(w[start:end], g, cache[start:end])
+	}
+	return nil
+}
+
+func (s *adaGradSolver) cacheOf(name string, size int) []float {
+	cache, ok := s.cache[name]
+	if !ok {
+		cache = make([]float, size)
+		s.cache[name] = cache
+	}
+	return cache
+}
+
+func (s *adaGradSolver) apply(w, g, cache []float) {
+	for i := range g {
+		d := float64(g[i])
+		if s.clip > 0 {
+			d = math.Max(-s.clip, math.Min(s.clip, d))
+		}
+		d += s.l2reg * float64(w[i])
+
+		c := float64(cache[i]) + d*d
+		cache[i] = float(c)
+		w[i] -= float(s.learnRate * d / (math.Sqrt(c) + s.eps))
+	}
+}
+
+type adaGradState struct {
+	LearnRate, Eps, Clip, L2Reg float64
+	Cache                       map[string][]float
+}
+
+func (s *adaGradSolver) GobEncode() (p []byte, err error) {
+	var buf bytes.Buffer
+	encoder := gob.NewEncoder(&buf)
+	state := adaGradState{
+		LearnRate: s.learnRate,
+		Eps:       s.eps,
+		Clip:      s.clip,
+		L2Reg:     s.l2reg,
+		Cache:     s.cache,
+	}
+	if err = encoder.Encode(state); err != nil {
+		return
+	}
+	return buf.Bytes(), nil
+}
+
+func (s *adaGradSolver) GobDecode(p []byte) (err error) {
+	var state adaGradState
+	decoder := gob.NewDecoder(bytes.NewReader(p))
+	if err = decoder.Decode(&state); err != nil {
+		return
+	}
+
+	s.learnRate = state.LearnRate
+	s.eps = state.Eps
+	s.clip = state.Clip
+	s.l2reg = state.L2Reg
+	s.cache = state.Cache
+	if s.cache == nil {
+		s.cache = make(map[string][]float)
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// recurrent cells
+const (
+	gruCell  = "gru"
+	lstmCell = "lstm"
+)
+
+// output layers
+const (
+	softmaxOutput = "softmax" // exactly one label per document
+	sigmoidOutput = "sigmoid" // any number of labels per document
+)
+
+// losses
+const (
+	nllLoss   = "nll"
+	focalLoss = "focal"
+)
+
+// attention types
+const (
+	vectorAttention = "vector" // a weight for each dimension of the hidden state
+	scalarAttention = "scalar" // one weight for the whole hidden state
+)
+
+// Spec describes the architecture of a model. It is saved with the model, so that loading a model rebuilds the same architecture.
+type Spec struct {
+	Layers        []LayerSpec `json:"layers"`        // recurrent layers, from the input up
+	Cell          string      `json:"cell"`          // type of the recurrent cells: gru or lstm
+	Bidirectional bool        `json:"bidirectional"` // whether each row is also read right to left
+	Attention     string      `json:"attention"`     // vector or scalar
+	Head          HeadSpec    `json:"head"`          // layers between the attention and the output
+	Loss          LossSpec    `json:"loss"`          // what the model is trained to minimise
+
+	MaxQuery   int    `json:"maxQuery"`   // words per row of a hierarchical model, that is, per sentence
+	MaxWords   int    `json:"maxWords"`   // words read by a sequential model, in total. 0 reads MaxQuery words
+	Sentences  int    `json:"sentences"`  // rows of a hierarchical model. 0 reads each document as one row
+	Overlength string `json:"overlength"` // policy for documents that do not fit. See (*Model).views
+
+	FreezeEmbedding bool `json:"freezeEmbedding"` // whether the word embeddings are left as they are during training
+}
+
+// LayerSpec describes a recurrent layer.
+type LayerSpec struct {
+	Hidden  int     `json:"hidden"`  // size of the hidden state
+	Dropout float64 `json:"dropout"` // probability of dropping each unit of the output, before it is fed to the layer above or to the attention
+}
+
+// HeadSpec describes the classification head, which turns the context into the probability of each label.
+type HeadSpec struct {
+	Hidden []DenseSpec `json:"hidden"` // fully connected layers between the context and the output, from the context up
+	Bias   bool        `json:"bias"`   // whether the output layer has a bias
+	Output string      `json:"output"` // softmax or sigmoid
+}
+
+// LossSpec describes the cost of an example.
+type LossSpec struct {
+	Kind  string  `json:"kind"`  // nll or focal
+	Gamma float64 `json:"gamma"` // focusing parameter of the focal loss. A gamma of 0 turns the focusing off, which leaves nll
+}
+
+// DenseSpec describes a fully connected layer.
+type DenseSpec struct {
+	Size       int     `json:"size"`
+	Activation string  `json:"activation"` // sigmoid, tanh, relu or linear
+	Dropout    float64 `json:"dropout"`    // probability of dropping each unit of the output
+}
+
+func defaultSpec() Spec {
+	return Spec{
+		Layers: []LayerSpec{
+			{Hidden: 100, Dropout: 0.5},
+			{Hidden: 30},
+		},
+		Cell:       gruCell,
+		Attention:  vectorAttention,
+		Head:       HeadSpec{Output: softmaxOutput},
+		Loss:       LossSpec{Kind: nllLoss, Gamma: 2},
+		MaxQuery:   MAXQUERY,
+		Overlength: keepHead,
+	}
+}
+
+func (s Spec) validate() error {
+	if len(s.Layers) == 0 {
+		return errors.New("A model needs at least one recurrent layer")
+	}
+	for i, l := range s.Layers {
+		if l.Hidden < 1 {
+			return errors.Errorf("Layer %d has a hidden size of %d", i, l.Hidden)
+		}
+		if l.Dropout < 0 || l.Dropout >= 1 {
+			return errors.Errorf("Layer %d has a dropout of %v. Expected [0, 1)", i, l.Dropout)
+		}
+	}
+
+	switch s.Cell {
+	case gruCell, lstmCell:
+	default:
+		return errors.Errorf("Unknown cell %q", s.Cell)
+	}
+
+	for i, l := range s.Head.Hidden {
+		if l.Size < 1 {
+			return errors.Errorf("Dense layer %d has a size of %d", i, l.Size)
+		}
+		if _, ok := activations[l.Activation]; !ok {
+			return errors.Errorf("Dense layer %d has an unknown activation %q", i, l.Activation)
+		}
+		if l.Dropout < 0 || l.Dropout >= 1 {
+			return errors.Errorf("Dense layer %d has a dropout of %v. Expected [0, 1)", i, l.Dropout)
+		}
+	}
+
+	switch s.Head.Output {
+	case softmaxOutput, sigmoidOutput:
+	default:
+		return errors.Errorf("Unknown output %q", s.Head.Output)
+	}
+
+	switch s.Loss.Kind {
+	case nllLoss, focalLoss:
+	default:
+		return errors.Errorf("Unknown loss %q", s.Loss.Kind)
+	}
+	if s.Loss.Gamma < 0 {
+		return errors.Errorf("Invalid focal loss gamma %v", s.Loss.Gamma)
+	}
+
+	switch s.Attention {
+	case vectorAttention, scalarAttention:
+	default:
+		return errors.Errorf("Unknown attention %q", s.Attention)
+	}
+
+	switch s.Overlength {
+	case keepHead, keepTail, slidingWindow, rejectLong:
+	default:
+		return errors.Errorf("Unknown over-length policy %q", s.Overlength)
+	}
+
+	if s.MaxQuery < 1 {
+		return errors.Errorf("Rows need at least 1 word. Got %d", s.MaxQuery)
+	}
+	if s.MaxWords < 0 {
+		return errors.Errorf("Invalid number of words %d", s.MaxWords)
+	}
+	if s.Sentences < 0 {
+		return errors.Errorf("Invalid number of sentences %d", s.Sentences)
+	}
+	return nil
+}
+
+// multiLabel reports whether a document may be tagged with more than one label.
+func (s Spec) multiLabel() bool { return s.Head.Output == sigmoidOutput }
+
+// rowSize is the number of words in a row. A sequential model reads the whole document as one row.
+func (s Spec) rowSize() int {
+	if s.Sentences == 0 && s.MaxWords > 0 {
+		return s.MaxWords
+	}
+	return s.MaxQuery
+}
+
+// contextSize is the size of the hidden states that are attended over.
+func (s Spec) contextSize() int {
+	c := s.Layers[len(s.Layers)-1].Hidden
+	if s.Bidirectional {
+		c *= 2
+	}
+	return c
+}
+
+// loadSpec reads the spec from the named JSON file, or uses the default spec if no file is given.
+// Fields missing from the file keep their defaults. Architecture flags that were set explicitly override the spec.
+func loadSpec(name string, set map[string]bool) (spec Spec, err error) {
+	spec = defaultSpec()
+	if name != "" {
+		var f *os.File
+		if f, err = os.Open(name); err != nil {
+			return
+		}
+		err = json.NewDecoder(f).Decode(&spec)
+		f.Close()
+		if err != nil {
+			return spec, errors.Wrapf(err, "Unable to decode spec %v", name)
+		}
+	}
+
+	if set["doc"] {
+		switch docMode {
+		case sequentialDoc:
+			spec.Sentences = 0
+		case hierarchicalDoc:
+			if maxSentences < 1 {
+				return spec, errors.Errorf("A hierarchical model needs at least 1 sentence. Got %d", maxSentences)
+			}
+			spec.Sentences = maxSentences
+		default:
+			return spec, errors.Errorf("Unknown document mode %q", docMode)
+		}
+	} else if set["sentences"] && spec.Sentences > 0 {
+		if maxSentences < 1 {
+			return spec, errors.Errorf("A hierarchical model needs at least 1 sentence. Got %d", maxSentences)
+		}
+		spec.Sentences = maxSentences
+	}
+	if set["maxWords"] {
+		spec.MaxWords = maxWords
+	}
+	if set["cell"] {
+		spec.Cell = cellType
+	}
+	if set["bidirectional"] {
+		spec.Bidirectional = bidirectional
+	}
+	if set["overlength"] {
+		spec.Overlength = overlength
+	}
+	if set["loss"] {
+		spec.Loss.Kind = lossKind
+	}
+	if set["gamma"] {
+		spec.Loss.Gamma = focalGamma
+	}
+	if set["bias"] {
+		spec.Head.Bias = bias
+	}
+	if set["multilabel"] {
+		spec.Head.Output = softmaxOutput
+		if multiLabel {
+			spec.Head.Output = sigmoidOutput
+		}
+	}
+	return spec, spec.validate()
+}
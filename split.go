@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// manifest records which files went into which partition, so that a split can be reused across runs.
+type manifest struct {
+	Seed     int64    `json:"seed"`
+	Train    []string `json:"train"`
+	Validate []string `json:"validate"`
+	Test     []string `json:"test"`
+}
+
+// splitExamples partitions the examples into training, validation and test sets.
+// If the manifest exists, the split is read from it. Otherwise a stratified split is made, and written to the manifest if one is given.
+func splitExamples(all []example) (train, validate, test []example, err error) {
+	if manifestLoc != "" {
+		if _, err = os.Stat(manifestLoc); err == nil {
+			return readManifest(manifestLoc, all)
+		}
+		if !os.IsNotExist(err) {
+			return
+		}
+	}
+
+	if trainRatio <= 0 || validRatio < 0 || trainRatio+validRatio > 1 {
+		err = errors.Errorf("Invalid split ratios. Train: %v, Validate: %v", trainRatio, validRatio)
+		return
+	}
+	train, validate, test = stratifiedSplit(all, len(labels), seed, trainRatio, validRatio)
+
+	if manifestLoc != "" {
+		err = writeManifest(manifestLoc, seed, train, validate, test)
+	}
+	return
+}
+
+// stratify groups the examples by target. Each group is shuffled.
+func stratify(exs []example, cats int, r *rand.Rand) [][]example {
+	byClass := make([][]example, cats)
+	for _, ex := range exs {
+		byClass[ex.target] = append(byClass[ex.target], ex)
+	}
+	for _, class := range byClass {
+		for i := range class {
+			j := r.Intn(i + 1)
+			class[i], class[j] = class[j], class[i]
+		}
+	}
+	return byClass
+}
+
+// stratifiedSplit splits each class by the given ratios, so every partition has the same distribution of targets.
+// Whatever is not used for training or validation is used for testing.
+func stratifiedSplit(exs []example, cats int, seed int64, trainRatio, validRatio float64) (train, validate, test []example) {
+	r := rand.New(rand.NewSource(seed))
+	for _, class := range stratify(exs, cats, r) {
+		nTrain := int(trainRatio * float64(len(class)))
+		nValid := int(validRatio * float64(len(class)))
+		train = append(train, class[:nTrain]...)
+		validate = append(validate, class[nTrain:nTrain+nValid]...)
+		test = append(test, class[nTrain+nValid:]...)
+	}
+	return
+}
+
+// stratifiedFolds deals each class out to k folds.
+func stratifiedFolds(exs []example, cats, k int, seed int64) [][]example {
+	r := rand.New(rand.NewSource(seed))
+	folds := make([][]example, k)
+	var i int
+	for _, class := range stratify(exs, cats, r) {
+		for _, ex := range class {
+			folds[i%k] = append(folds[i%k], ex)
+			i++
+		}
+	}
+	return folds
+}
+
+// checkFolds makes sure that k folds each hold out at least one example of every label that has examples.
+func checkFolds(exs []example, cats, k int) error {
+	counts := make([]int, cats)
+	for _, ex := range exs {
+		counts[ex.target]++
+	}
+	for t, n := range counts {
+		if n > 0 && n < k {
+			return errors.Errorf("Cannot run %d-fold cross validation: %v has only %d examples", k, Target(t), n)
+		}
+	}
+	return nil
+}
+
+func writeManifest(name string, seed int64, train, validate, test []example) (err error) {
+	man := manifest{
+		Seed:     seed,
+		Train:    exampleNames(train),
+		Validate: exampleNames(validate),
+		Test:     exampleNames(test),
+	}
+
+	var f *os.File
+	if f, err = os.Create(name); err != nil {
+		return
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "\t")
+	if err = enc.Encode(man); err != nil {
+		f.Close()
+		return
+	}
+	return f.Close()
+}
+
+func readManifest(name string, all []example) (train, validate, test []example, err error) {
+	var f *os.File
+	if f, err = os.Open(name); err != nil {
+		return
+	}
+	defer f.Close()
+
+	var man manifest
+	if err = json.NewDecoder(f).Decode(&man); err != nil {
+		err = errors.Wrapf(err, "Unable to decode manifest %v", name)
+		return
+	}
+
+	byName := make(map[string]example)
+	for _, ex := range all {
+		byName[ex.name] = ex
+	}
+
+	lookup := func(names []string) ([]example, error) {
+		retVal := make([]example, 0, len(names))
+		for _, n := range names {
+			ex, ok := byName[n]
+			if !ok {
+				return nil, errors.Errorf("%v is in the manifest but not in the corpus", n)
+			}
+			retVal = append(retVal, ex)
+			delete(byName, n)
+		}
+		return retVal, nil
+	}
+
+	if train, err = lookup(man.Train); err != nil {
+		return
+	}
+	if validate, err = lookup(man.Validate); err != nil {
+		return
+	}
+	if test, err = lookup(man.Test); err != nil {
+		return
+	}
+	if len(byName) > 0 {
+		log.Printf("%d files in the corpus are not in the manifest %v. They are ignored", len(byName), name)
+	}
+	return
+}
+
+func exampleNames(exs []example) []string {
+	retVal := make([]string, len(exs))
+	for i, ex := range exs {
+		retVal[i] = ex.name
+	}
+	return retVal
+}
+
+// crossValidate trains a fresh model for each of k folds, evaluating it on the fold it was not trained on.
+// The mean and standard deviation of the accuracy and macro F1 across the folds are reported.
+func crossValidate(spec Spec, exs []example, k int) (err error) {
+	if err = checkFolds(exs, len(labels), k); err != nil {
+		return
+	}
+	folds := stratifiedFolds(exs, len(labels), k, seed)
+	accs := make([]float64, k)
+	f1s := make([]float64, k)
+	for i, heldOut := range folds {
+		var train []example
+		for j, fold := range folds {
+			if j != i {
+				train = append(train, fold...)
+			}
+		}
+
+		if train, err = resample(train, resampling, len(labels), seed); err != nil {
+			return
+		}
+
+		var m *Model
+		if m, err = modelFromDeps(spec); err != nil {
+			return
+		}
+		if err = weighClasses(m, train, false); err != nil {
+			return
+		}
+		train = m.trainingViews(train)
+		heldOut = m.admissible(heldOut)
+		if len(heldOut) == 0 {
+			return errors.Errorf("Fold %d holds out no example the model can read", i)
+		}
+		solver := newAdaGradSolver(0.05, 3.0, 0.000001)
+		st := newTrainState(seed, len(train))
+		if err = fit(st, m, solver, train, heldOut); err != nil {
+			return errors.Wrapf(err, "Fold %d", i)
+		}
+
+		if accs[i], f1s[i], _, err = checkAcc(m, heldOut); err != nil {
+			return
+		}
+		log.Printf("Fold %d | %f | %f", i, accs[i], f1s[i])
+	}
+
+	accMean, accStd := meanStd(accs)
+	f1Mean, f1Std := meanStd(f1s)
+	log.Printf("%d-fold cross validation. Accuracy: %f ± %f. Macro F1: %f ± %f", k, accMean, accStd, f1Mean, f1Std)
+	return nil
+}
+
+// meanStd returns the mean and the sample standard deviation.
+func meanStd(a []float64) (mean, std float64) {
+	for _, v := range a {
+		mean += v
+	}
+	mean /= float64(len(a))
+	if len(a) < 2 {
+		return mean, 0
+	}
+
+	for _, v := range a {
+		std += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(std / float64(len(a)-1))
+}
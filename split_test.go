@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testExamples makes counts[i] examples of each target i.
+func testExamples(counts ...int) []example {
+	var retVal []example
+	for t, n := range counts {
+		for i := 0; i < n; i++ {
+			retVal = append(retVal, example{name: fmt.Sprintf("%d/%d.txt", t, i), target: Target(t)})
+		}
+	}
+	return retVal
+}
+
+func countTargets(exs []example, cats int) []int {
+	retVal := make([]int, cats)
+	for _, ex := range exs {
+		retVal[ex.target]++
+	}
+	return retVal
+}
+
+func TestStratifiedSplit(t *testing.T) {
+	cases := []struct {
+		counts                 []int
+		trainRatio, validRatio float64
+		train, validate, test  []int
+	}{
+		{[]int{100, 20, 10}, 0.7, 0.15, []int{70, 14, 7}, []int{15, 3, 1}, []int{15, 3, 2}},
+		{[]int{10, 10}, 0.5, 0.5, []int{5, 5}, []int{5, 5}, []int{0, 0}},
+		{[]int{3, 1}, 0.8, 0, []int{2, 0}, []int{0, 0}, []int{1, 1}},
+	}
+
+	for _, tc := range cases {
+		exs := testExamples(tc.counts...)
+		train, validate, test := stratifiedSplit(exs, len(tc.counts), 1, tc.trainRatio, tc.validRatio)
+
+		if got := countTargets(train, len(tc.counts)); !reflect.DeepEqual(got, tc.train) {
+			t.Errorf("%v: train has %v. Expected %v", tc.counts, got, tc.train)
+		}
+		if got := countTargets(validate, len(tc.counts)); !reflect.DeepEqual(got, tc.validate) {
+			t.Errorf("%v: validate has %v. Expected %v", tc.counts, got, tc.validate)
+		}
+		if got := countTargets(test, len(tc.counts)); !reflect.DeepEqual(got, tc.test) {
+			t.Errorf("%v: test has %v. Expected %v", tc.counts, got, tc.test)
+		}
+
+		seen := make(map[string]bool)
+		for _, part := range [][]example{train, validate, test} {
+			for _, ex := range part {
+				if seen[ex.name] {
+					t.Errorf("%v: %v is in more than one partition", tc.counts, ex.name)
+				}
+				seen[ex.name] = true
+			}
+		}
+		if len(seen) != len(exs) {
+			t.Errorf("%v: %d examples were split. Expected %d", tc.counts, len(seen), len(exs))
+		}
+
+		again, _, _ := stratifiedSplit(exs, len(tc.counts), 1, tc.trainRatio, tc.validRatio)
+		if !reflect.DeepEqual(exampleNames(again), exampleNames(train)) {
+			t.Errorf("%v: the same seed gave a different split", tc.counts)
+		}
+	}
+}
+
+func TestStratifiedFolds(t *testing.T) {
+	cases := []struct {
+		counts []int
+		k      int
+	}{
+		{[]int{10, 10, 10}, 5},
+		{[]int{23, 7}, 3},
+		{[]int{2, 1}, 4},
+	}
+
+	for _, tc := range cases {
+		exs := testExamples(tc.counts...)
+		folds := stratifiedFolds(exs, len(tc.counts), tc.k, 1)
+		if len(folds) != tc.k {
+			t.Fatalf("%v: %d folds. Expected %d", tc.counts, len(folds), tc.k)
+		}
+
+		seen := make(map[string]bool)
+		min, max := len(exs), 0
+		for _, fold := range folds {
+			for _, ex := range fold {
+				if seen[ex.name] {
+					t.Errorf("%v: %v is in more than one fold", tc.counts, ex.name)
+				}
+				seen[ex.name] = true
+			}
+			if len(fold) < min {
+				min = len(fold)
+			}
+			if len(fold) > max {
+				max = len(fold)
+			}
+		}
+		if len(seen) != len(exs) {
+			t.Errorf("%v: %d examples were dealt. Expected %d", tc.counts, len(seen), len(exs))
+		}
+		if max-min > 1 {
+			t.Errorf("%v: folds range from %d to %d examples. Expected them to differ by at most 1", tc.counts, min, max)
+		}
+	}
+}
+
+func TestCheckFolds(t *testing.T) {
+	cases := []struct {
+		counts []int
+		k      int
+		ok     bool
+	}{
+		{[]int{10, 10, 10}, 5, true},
+		{[]int{23, 7}, 7, true},
+		{[]int{23, 7}, 8, false},
+		{[]int{2, 1}, 4, false},
+		{[]int{4, 0, 4}, 4, true},
+	}
+
+	for _, tc := range cases {
+		err := checkFolds(testExamples(tc.counts...), len(tc.counts), tc.k)
+		if (err == nil) != tc.ok {
+			t.Errorf("%v in %d folds: error %v. Expected an error: %v", tc.counts, tc.k, err, !tc.ok)
+		}
+	}
+}
+
+func TestManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "drongo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "manifest.json")
+
+	exs := testExamples(10, 6, 4)
+	train, validate, test := stratifiedSplit(exs, 3, 7, 0.6, 0.2)
+	if err = writeManifest(name, 7, train, validate, test); err != nil {
+		t.Fatal(err)
+	}
+
+	// the corpus gained a file since the manifest was written. It is left out of every partition
+	all := append(testExamples(10, 6, 4), example{name: "new.txt"})
+	gotTrain, gotValidate, gotTest, err := readManifest(name, all)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(exampleNames(gotTrain), exampleNames(train)) {
+		t.Errorf("Read train %v. Expected %v", exampleNames(gotTrain), exampleNames(train))
+	}
+	if !reflect.DeepEqual(exampleNames(gotValidate), exampleNames(validate)) {
+		t.Errorf("Read validate %v. Expected %v", exampleNames(gotValidate), exampleNames(validate))
+	}
+	if !reflect.DeepEqual(exampleNames(gotTest), exampleNames(test)) {
+		t.Errorf("Read test %v. Expected %v", exampleNames(gotTest), exampleNames(test))
+	}
+
+	// a file that was in the manifest is gone from the corpus
+	if _, _, _, err = readManifest(name, exs[1:]); err == nil {
+		t.Error("Expected an error for a file missing from the corpus")
+	}
+}